@@ -2,31 +2,267 @@ package cohorts
 
 import (
 	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/dto"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
 	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
+	"net/http"
+	"strconv"
 )
 
 type CohortsService struct {
-	asynq             *asynq.Client
-	cohortsRepository models.IUserCohortRepository
+	asynq                  *asynq.Client
+	cohortsRepository      models.ICachedUserCohortRepository
+	savedCohortsRepository models.ISavedCohortRepository
 }
 
 type CohortsServiceInterface interface {
-	GetUserCohorts(userId int) ([]models.UserCohort, error)
+	GetUserCohorts(ctx *gin.Context)    // Preview of an audience: its size and a sample of its users, to check before broadcasting.
+	GetCohortStats(ctx *gin.Context)    // How the active users split into the built-in cohorts.
+	GetCohortCount(ctx *gin.Context)    // Number of active users in a built-in cohort.
+	InvalidateCache(ctx *gin.Context)   // Drops the cached stats and counts, for when users changed outside of PingMeMaybe.
+	CreateSavedCohort(ctx *gin.Context) // Saves a named cohort, broadcasts and lifecycle rules use it with filters.saved_cohort_id.
+	ListSavedCohorts(ctx *gin.Context)  // All saved cohorts.
+	GetSavedCohort(ctx *gin.Context)    // A single saved cohort.
+	UpdateSavedCohort(ctx *gin.Context) // Replaces the definition of a saved cohort, broadcasts and rules using it pick it up from then on.
+	DeleteSavedCohort(ctx *gin.Context) // Deletes a saved cohort.
 }
 
-func NewCohortsService(asynq *asynq.Client, cohortsRepository models.IUserCohortRepository) CohortsServiceInterface {
+// Constructor
+func NewCohortsService(asynq *asynq.Client, cohortsRepository models.ICachedUserCohortRepository, savedCohortsRepository models.ISavedCohortRepository) CohortsServiceInterface {
 	return &CohortsService{
-		asynq:             asynq,
-		cohortsRepository: cohortsRepository,
+		asynq:                  asynq,
+		cohortsRepository:      cohortsRepository,
+		savedCohortsRepository: savedCohortsRepository,
 	}
 }
 
-func (c *CohortsService) GetUserCohorts(userId int) ([]models.UserCohort, error) {
-	return nil, nil
-	// TODO: WIP
-	//cohorts, err := c.cohortsRepository.GetCohortUsers()
-	//if err != nil {
-	//	return nil, err
-	//}
-	//return cohorts, nil
+// countsContext is the context to read cohort counts with, ?fresh=true reads them from the database instead of the cache
+func countsContext(ctx *gin.Context) context.Context {
+	if fresh, _ := strconv.ParseBool(ctx.Query("fresh")); fresh {
+		return models.WithFreshCounts(ctx)
+	}
+	return ctx
+}
+
+func (c *CohortsService) GetUserCohorts(ctx *gin.Context) {
+	var body dto.PostCohortPreviewDTO
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.SampleSize == 0 {
+		body.SampleSize = dto.DefaultPreviewSampleSize
+	}
+
+	total, err := c.cohortsRepository.CountCohortUsers(countsContext(ctx), body.CohortType, body.Filters)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Only a saved cohort the filters refer to can be missing
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not count cohort users"})
+		return
+	}
+
+	// The sample is the newest users, paging of the request's filters is ignored
+	sampleFilters := models.CohortFilters{}
+	if body.Filters != nil {
+		sampleFilters = *body.Filters
+	}
+	sampleFilters.Limit = body.SampleSize
+	sampleFilters.Offset = 0
+
+	users, err := c.cohortsRepository.GetCohortUsers(ctx, body.CohortType, &sampleFilters)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch cohort users"})
+		return
+	}
+	if users == nil {
+		users = []models.UserCohort{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"cohort_type": body.CohortType, "total": total, "sample": users})
+}
+
+func (c *CohortsService) GetCohortStats(ctx *gin.Context) {
+	stats, err := c.cohortsRepository.GetCohortStats(countsContext(ctx))
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch cohort stats"})
+		return
+	}
+	if stats == nil {
+		stats = []models.CohortStats{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"stats": stats})
+}
+
+func (c *CohortsService) GetCohortCount(ctx *gin.Context) {
+	cohortType := models.UserCohortType(ctx.Param("type"))
+	if !cohortType.IsValid() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown cohort type: %s", cohortType)})
+		return
+	}
+
+	count, err := c.cohortsRepository.GetCohortUserCount(countsContext(ctx), cohortType)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not count cohort users"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"cohort_type": cohortType, "count": count})
+}
+
+func (c *CohortsService) InvalidateCache(ctx *gin.Context) {
+	if err := c.cohortsRepository.InvalidateCounts(ctx); err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not invalidate the cohort cache"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// invalidateCounts drops the cached counts after a saved cohort changed, counts filtered by it would be stale
+func (c *CohortsService) invalidateCounts(ctx *gin.Context) {
+	if err := c.cohortsRepository.InvalidateCounts(ctx); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// respondWithSaveError answers with the status matching an error of CreateCohort or UpdateCohort
+func respondWithSaveError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "cohort not found"})
+	case errors.Is(err, models.ErrSavedCohortNameConflict):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not save cohort"})
+	}
+}
+
+func (c *CohortsService) CreateSavedCohort(ctx *gin.Context) {
+	var body dto.PostSavedCohortDTO
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cohort := body.ToCohort()
+	id, err := c.savedCohortsRepository.CreateCohort(ctx, cohort)
+	if err != nil {
+		respondWithSaveError(ctx, err)
+		return
+	}
+
+	cohort.ID = id
+	ctx.JSON(http.StatusCreated, gin.H{"cohort": cohort})
+}
+
+func (c *CohortsService) ListSavedCohorts(ctx *gin.Context) {
+	cohorts, err := c.savedCohortsRepository.ListCohorts(ctx)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not list cohorts"})
+		return
+	}
+	if cohorts == nil {
+		cohorts = []models.SavedCohort{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"cohorts": cohorts})
+}
+
+func (c *CohortsService) GetSavedCohort(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid cohort id"})
+		return
+	}
+
+	cohort, err := c.savedCohortsRepository.GetCohortByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "cohort not found"})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch cohort"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"cohort": cohort})
+}
+
+func (c *CohortsService) UpdateSavedCohort(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid cohort id"})
+		return
+	}
+
+	var body dto.PostSavedCohortDTO
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cohort := body.ToCohort()
+	cohort.ID = id
+	if err := c.savedCohortsRepository.UpdateCohort(ctx, cohort); err != nil {
+		respondWithSaveError(ctx, err)
+		return
+	}
+	c.invalidateCounts(ctx)
+
+	ctx.JSON(http.StatusOK, gin.H{"cohort": cohort})
+}
+
+func (c *CohortsService) DeleteSavedCohort(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid cohort id"})
+		return
+	}
+
+	deleted, err := c.savedCohortsRepository.DeleteCohort(ctx, id)
+	if errors.Is(err, models.ErrSavedCohortInUse) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete cohort"})
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "cohort not found"})
+		return
+	}
+	c.invalidateCounts(ctx)
+
+	ctx.JSON(http.StatusOK, gin.H{"success": true})
 }
@@ -0,0 +1,178 @@
+package lifecycle
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/dto"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"net/http"
+	"strconv"
+)
+
+type lifecycleService struct {
+	lifecycleRuleRepository models.ILifecycleRuleRepository
+	savedCohortRepository   models.ISavedCohortRepository
+}
+
+type LifecycleServiceInterface interface {
+	CreateRule(ctx *gin.Context) // Saves a rule, the processor's lifecycle scheduler picks it up on its next run.
+	ListRules(ctx *gin.Context)  // All rules with their run stats.
+	GetRule(ctx *gin.Context)    // A single rule with its run stats.
+	UpdateRule(ctx *gin.Context) // Replaces the definition of a rule, set enabled to false to pause it.
+	DeleteRule(ctx *gin.Context) // Deletes a rule along with its cooldown history.
+}
+
+// Constructor
+func NewLifecycleService(lifecycleRuleRepository models.ILifecycleRuleRepository, savedCohortRepository models.ISavedCohortRepository) LifecycleServiceInterface {
+	return &lifecycleService{
+		lifecycleRuleRepository,
+		savedCohortRepository,
+	}
+}
+
+// respondWithSaveError answers with the status matching an error of CreateRule or UpdateRule
+func respondWithSaveError(ctx *gin.Context, err error) {
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "lifecycle rule not found"})
+	case errors.Is(err, models.ErrLifecycleRuleNameConflict):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrLifecycleRuleTemplateNotFound):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not save lifecycle rule"})
+	}
+}
+
+// checkSavedCohort answers with an error and returns false when the rule refers to a saved cohort that doesn't exist
+func (l *lifecycleService) checkSavedCohort(ctx *gin.Context, filters *models.CohortFilters) bool {
+	if filters == nil || filters.SavedCohortID == nil {
+		return true
+	}
+
+	_, err := l.savedCohortRepository.GetCohortByID(ctx, *filters.SavedCohortID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("saved cohort %d not found", *filters.SavedCohortID)})
+		return false
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch saved cohort"})
+		return false
+	}
+	return true
+}
+
+func (l *lifecycleService) CreateRule(ctx *gin.Context) {
+	var body dto.PostLifecycleRuleDTO
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !l.checkSavedCohort(ctx, body.Filters) {
+		return
+	}
+
+	rule := body.ToRule()
+	id, err := l.lifecycleRuleRepository.CreateRule(ctx, rule)
+	if err != nil {
+		respondWithSaveError(ctx, err)
+		return
+	}
+
+	rule.ID = id
+	ctx.JSON(http.StatusCreated, gin.H{"rule": rule})
+}
+
+func (l *lifecycleService) ListRules(ctx *gin.Context) {
+	rules, err := l.lifecycleRuleRepository.ListRules(ctx, false)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not list lifecycle rules"})
+		return
+	}
+	if rules == nil {
+		rules = []models.LifecycleRule{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+func (l *lifecycleService) GetRule(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return
+	}
+
+	rule, err := l.lifecycleRuleRepository.GetRuleByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "lifecycle rule not found"})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch lifecycle rule"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+func (l *lifecycleService) UpdateRule(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return
+	}
+
+	var body dto.PostLifecycleRuleDTO
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !l.checkSavedCohort(ctx, body.Filters) {
+		return
+	}
+
+	rule := body.ToRule()
+	rule.ID = id
+	if err := l.lifecycleRuleRepository.UpdateRule(ctx, rule); err != nil {
+		respondWithSaveError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"rule": rule})
+}
+
+func (l *lifecycleService) DeleteRule(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return
+	}
+
+	deleted, err := l.lifecycleRuleRepository.DeleteRule(ctx, id)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete lifecycle rule"})
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "lifecycle rule not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": true})
+}
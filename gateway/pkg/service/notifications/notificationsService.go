@@ -1,35 +1,74 @@
 package notifications
 
 import (
+	"PingMeMaybe/libs/config"
 	"PingMeMaybe/libs/db/models"
 	"PingMeMaybe/libs/dto"
 	"PingMeMaybe/libs/messagePatterns"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	_ "encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 )
 
+// How long asynq holds the uniqueness lock of a task queued with an Idempotency-Key
+const idempotencyUniqueTTL = 24 * time.Hour
+
+// Page size of GET /notifications when no limit is given, and the largest limit accepted
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
 type notificationsService struct {
 	// can be left empty also just for the sake of interface implementation
 	asynq                  *asynq.Client
+	inspector              *asynq.Inspector
 	notificationRepository models.INotificationRepository
+	deliveryRepository     models.IDeliveryRepository
+	outboxRepository       models.IOutboxRepository
+	templateRepository     models.ITemplateRepository
+	savedCohortRepository  models.ISavedCohortRepository
+	snapshotRepository     models.IBroadcastSnapshotRepository
+	// Locale every broadcast template needs a variant for, see config.GetDefaultLocale
+	defaultLocale string
+	// Hosts webhook_url may point at, see config.GetWebhookAllowlist
+	webhookAllowlist config.WebhookAllowlist
 }
 
 type NotificationsServiceInterface interface {
-	QueueNotification(ctx *gin.Context)  // For high priority non-bulk transactional notifications.
-	QueueBulkBroadcast(ctx *gin.Context) // Initiates bulk requests, passed to the bulk initiating queue.
+	QueueNotification(ctx *gin.Context)       // For high priority non-bulk transactional notifications.
+	QueueBulkBroadcast(ctx *gin.Context)      // Initiates bulk requests, passed to the bulk initiating queue.
+	GetNotification(ctx *gin.Context)         // Status of a notification, with per-recipient delivery counts and a broadcast's audience snapshot.
+	ListNotifications(ctx *gin.Context)       // Filter by status and creation time, cursor paginated.
+	GetNotificationByTaskID(ctx *gin.Context) // Look up a notification by the asynq task id returned when it was queued.
+	CancelNotification(ctx *gin.Context)      // Cancels a scheduled notification that hasn't started sending.
+	GetAudience(ctx *gin.Context)             // The users a broadcast targeted, from its snapshot, cursor paginated.
 }
 
 // Constructor
-func NewNotificationsService(asynq *asynq.Client, notificationsRepository models.INotificationRepository) NotificationsServiceInterface {
+func NewNotificationsService(asynq *asynq.Client, inspector *asynq.Inspector, notificationsRepository models.INotificationRepository, deliveriesRepository models.IDeliveryRepository, outboxRepository models.IOutboxRepository, templateRepository models.ITemplateRepository, savedCohortRepository models.ISavedCohortRepository, snapshotRepository models.IBroadcastSnapshotRepository, defaultLocale string, webhookAllowlist config.WebhookAllowlist) NotificationsServiceInterface {
 	return &notificationsService{
 		asynq,
+		inspector,
 		notificationsRepository,
+		deliveriesRepository,
+		outboxRepository,
+		templateRepository,
+		savedCohortRepository,
+		snapshotRepository,
+		defaultLocale,
+		webhookAllowlist,
 	}
 }
 
@@ -42,34 +81,560 @@ func (n *notificationsService) QueueNotification(ctx *gin.Context) {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": notif})
 		return
 	}
-	payload, err := json.Marshal(dto.PostNotificationDTO{
-		Title:       notif.Title,
-		Description: notif.Description,
-		Link:        notif.Link,
-	})
-	task := asynq.NewTask(messagePatterns.DispatchNotification, payload)
+	if err := notif.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !n.checkWebhookURL(ctx, notif) {
+		return
+	}
+	if !n.applyTemplate(ctx, &notif, false) {
+		return
+	}
 
-	info, err := n.asynq.Enqueue(task, asynq.MaxRetry(10), asynq.Timeout(3*time.Minute))
-	if err != nil {
-		fmt.Println(err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": notif})
+	// Single notifications are usually someone waiting on an OTP or a reset link
+	if notif.Priority == "" {
+		notif.Priority = messagePatterns.PriorityTransactional
+	}
+
+	// Clients retrying on timeouts send the same Idempotency-Key, they get the original notification back
+	idempotencyKey := ctx.GetHeader("Idempotency-Key")
+	if len(idempotencyKey) > 255 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
 		return
 	}
+	if idempotencyKey != "" {
+		idempotencyKey = scopedIdempotencyKey(ctx.GetHeader("X-Client-ID"), idempotencyKey)
+		if n.respondWithExistingNotification(ctx, idempotencyKey) {
+			return
+		}
+	}
 
-	// Save the notification trigger entry in postgres
-	notificationPayload, err := json.Marshal(models.NotificationPayload{Link: notif.Link})
+	// The notification and its task are written in one transaction, the processor relays the task into asynq.
+	// The task id is generated up front so it can be stored as the transaction id of the notification.
+	taskID := uuid.NewString()
+	notificationPayload, err := json.Marshal(models.NotificationPayload{Link: notif.Link, WebhookURL: notif.WebhookURL})
 	notificationObject := models.Notification{
 		Title:         notif.Title,
 		Description:   notif.Description,
 		Payload:       notificationPayload,
+		ChannelID:     notif.ChannelID,
 		Status:        models.NotificationStatusProcessing,
-		TransactionId: info.ID,
+		TransactionId: taskID,
+	}
+	message := models.OutboxMessage{
+		TaskID:   taskID,
+		TaskType: messagePatterns.DispatchNotification,
+		Queue:    messagePatterns.QueueForPriority(notif.Priority),
+		MaxRetry: 10,
+		Timeout:  3 * time.Minute,
+		Deadline: notif.ExpiresAt,
+	}
+	if notif.IsScheduled() {
+		notificationObject.Status = models.NotificationStatusScheduled
+		message.ProcessAt = notif.SendAt
+	}
+	if idempotencyKey != "" {
+		// The scoped key is the task id as well, so asynq itself also refuses a second task for it
+		taskID = idempotencyKey
+		notificationObject.TransactionId = taskID
+		notificationObject.IdempotencyKey = &idempotencyKey
+		message.TaskID = taskID
+		message.UniqueTTL = idempotencyUniqueTTL
+	}
+
+	var payload []byte
+	id, err := n.notificationRepository.CreateNotificationWithOutbox(ctx, notificationObject, message, func(notificationID int) ([]byte, error) {
+		var marshalErr error
+		payload, marshalErr = json.Marshal(dto.DispatchNotificationDTO{
+			PostNotificationDTO: dto.PostNotificationDTO{
+				Title:       notif.Title,
+				Description: notif.Description,
+				Link:        notif.Link,
+				ChannelID:   notif.ChannelID,
+				WebhookURL:  notif.WebhookURL,
+				SendAt:      notif.SendAt,
+				ExpiresAt:   notif.ExpiresAt,
+				UserID:      notif.UserID,
+				Priority:    notif.Priority,
+				TemplateID:  notif.TemplateID,
+			},
+			NotificationID: notificationID,
+		})
+		return payload, marshalErr
+	})
+	// A concurrent request with the same key won the race, answer with its notification
+	if errors.Is(err, models.ErrIdempotencyKeyConflict) && n.respondWithExistingNotification(ctx, idempotencyKey) {
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": notif})
+		return
+	}
+
+	log.Printf("saved task to outbox: id=%s queue=%s", taskID, message.Queue)
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "task_id": taskID, "queue": message.Queue, "notification_id": id, "payload": payload})
+}
+
+// scopedIdempotencyKey is what an Idempotency-Key is stored and queued as. Keys are scoped to the client sending them,
+// and hashed into their own namespace so they can't collide with the task ids the processor derives itself.
+func scopedIdempotencyKey(clientID string, key string) string {
+	sum := sha256.Sum256([]byte(clientID + "\x00" + key))
+	return "idem-" + hex.EncodeToString(sum[:])
+}
+
+// respondWithExistingNotification answers with the notification saved for an idempotency key,
+// it returns false without responding when there is none
+func (n *notificationsService) respondWithExistingNotification(ctx *gin.Context, idempotencyKey string) bool {
+	existing, err := n.notificationRepository.GetNotificationByIdempotencyKey(ctx, idempotencyKey)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not check idempotency key"})
+		return true
+	}
+
+	log.Printf("idempotent replay: key=%s notification=%d task=%s", idempotencyKey, existing.ID, existing.TransactionId)
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "task_id": existing.TransactionId, "notification_id": existing.ID, "idempotent_replay": true})
+	return true
+}
+
+// checkWebhookURL checks that the webhook_url of a notification is on the allowlist, webhooks carry the recipient's
+// details. It returns false after responding with an error.
+func (n *notificationsService) checkWebhookURL(ctx *gin.Context, notif dto.PostNotificationDTO) bool {
+	if notif.WebhookURL == "" || n.webhookAllowlist.Allows(notif.WebhookURL) {
+		return true
 	}
-	id, err := n.notificationRepository.CreateNotification(ctx, notificationObject)
-	log.Printf("enqueued task: id=%s queue=%s", info.ID, info.Queue)
-	ctx.JSON(http.StatusOK, gin.H{"success": true, "task_id": info.ID, "queue": info.Queue, "notification_id": id, "payload": payload})
+	ctx.JSON(http.StatusBadRequest, gin.H{"error": "webhook_url is not on WEBHOOK_ALLOWED_HOSTS"})
+	return false
+}
+
+// applyTemplate checks that the template of a notification exists and replaces the content of the notification
+// with the unrendered default-locale variant, so the saved notification shows what is being sent.
+// Picking the recipient's variant and rendering it is left to the processor.
+// With requireDefaultLocale, a template without a default-locale variant is rejected.
+// It returns false after responding with an error.
+func (n *notificationsService) applyTemplate(ctx *gin.Context, notif *dto.PostNotificationDTO, requireDefaultLocale bool) bool {
+	if notif.TemplateID == nil {
+		return true
+	}
+
+	template, err := n.templateRepository.GetTemplateByID(ctx, *notif.TemplateID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("template %d does not exist", *notif.TemplateID)})
+		return false
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch template"})
+		return false
+	}
+
+	variant := template.Variant(n.defaultLocale, n.defaultLocale)
+	if variant == nil && requireDefaultLocale {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("template %d has no variant for the default locale %s", template.ID, n.defaultLocale)})
+		return false
+	}
+	if variant == nil && len(template.Variants) == 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("template %d has no variants", template.ID)})
+		return false
+	}
+	if variant == nil {
+		variant = &template.Variants[0]
+	}
+
+	notif.Title = variant.Title
+	notif.Description = variant.Description
+	notif.Link = variant.Link
+	return true
 }
 
 func (n *notificationsService) QueueBulkBroadcast(ctx *gin.Context) {
-	panic("implement me")
+	var broadcast dto.PostBulkBroadcastDTO
+	err := ctx.BindJSON(&broadcast)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := broadcast.Notification.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !n.checkWebhookURL(ctx, broadcast.Notification) {
+		return
+	}
+	// Every recipient of a broadcast has to get something, whatever their locale
+	if !n.applyTemplate(ctx, &broadcast.Notification, true) {
+		return
+	}
+	if broadcast.LocalDelivery != nil {
+		if err := broadcast.LocalDelivery.Validate(); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	// The critical queue is kept for single transactional notifications, a broadcast would flood it
+	if broadcast.Notification.Priority == messagePatterns.PriorityTransactional {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "broadcasts can't use the transactional priority"})
+		return
+	}
+	if broadcast.Notification.Priority == "" {
+		broadcast.Notification.Priority = messagePatterns.PriorityBulk
+	}
+	if broadcast.CohortType != "" && !broadcast.CohortType.IsValid() {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown cohort type: %s", broadcast.CohortType)})
+		return
+	}
+	if err := broadcast.Filters.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !n.checkSavedCohort(ctx, broadcast.Filters) {
+		return
+	}
+	if err := broadcast.Exclusions.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if broadcast.Exclusions != nil {
+		for _, excluded := range broadcast.Exclusions.Cohorts {
+			if !n.checkSavedCohort(ctx, excluded.Filters) {
+				return
+			}
+		}
+	}
+
+	// Same as QueueNotification, the broadcast and its InitiateBulkBroadcast task are saved together
+	taskID := uuid.NewString()
+	notificationPayload, err := json.Marshal(models.NotificationPayload{Link: broadcast.Notification.Link, WebhookURL: broadcast.Notification.WebhookURL})
+	notificationObject := models.Notification{
+		Title:         broadcast.Notification.Title,
+		Description:   broadcast.Notification.Description,
+		Payload:       notificationPayload,
+		ChannelID:     broadcast.Notification.ChannelID,
+		Status:        models.NotificationStatusProcessing,
+		TransactionId: taskID,
+	}
+	message := models.OutboxMessage{
+		TaskID:   taskID,
+		TaskType: messagePatterns.InitiateBulkBroadcast,
+		// Only plans the chunks, the fan-out itself goes to the low queue
+		Queue:    messagePatterns.QueueDefault,
+		MaxRetry: 10,
+		// Taking the snapshot streams the whole cohort, a retry after a timeout starts it over
+		Timeout: 30 * time.Minute,
+	}
+	// A scheduled broadcast starts its fan-out at send_at, expires_at is enforced on every recipient's task
+	if broadcast.Notification.IsScheduled() {
+		notificationObject.Status = models.NotificationStatusScheduled
+		message.ProcessAt = broadcast.Notification.SendAt
+	}
+
+	id, err := n.notificationRepository.CreateNotificationWithOutbox(ctx, notificationObject, message, func(notificationID int) ([]byte, error) {
+		// The fan-out tasks need the broadcast id to refer back to it
+		return json.Marshal(dto.BulkBroadcastTaskDTO{
+			NotificationID:       notificationID,
+			PostBulkBroadcastDTO: broadcast,
+		})
+	})
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not save broadcast"})
+		return
+	}
+
+	log.Printf("saved broadcast to outbox: id=%s queue=%s cohort=%s", taskID, message.Queue, broadcast.CohortType)
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "task_id": taskID, "queue": message.Queue, "notification_id": id})
+}
+
+// checkSavedCohort checks that the saved cohort the filters refer to exists,
+// it returns false after responding with an error
+func (n *notificationsService) checkSavedCohort(ctx *gin.Context, filters *models.CohortFilters) bool {
+	if filters == nil || filters.SavedCohortID == nil {
+		return true
+	}
+
+	_, err := n.savedCohortRepository.GetCohortByID(ctx, *filters.SavedCohortID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("saved cohort %d not found", *filters.SavedCohortID)})
+		return false
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch saved cohort"})
+		return false
+	}
+	return true
+}
+
+func (n *notificationsService) GetNotification(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid notification id"})
+		return
+	}
+
+	notification, err := n.notificationRepository.GetNotificationByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch notification"})
+		return
+	}
+
+	stats, err := n.deliveryRepository.GetDeliveryStats(ctx, id)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch delivery stats"})
+		return
+	}
+	response := gin.H{"notification": notification, "delivery_stats": stats}
+
+	// The audience a broadcast was frozen with, once its fan-out has started
+	snapshot, err := n.snapshotRepository.GetSnapshot(ctx, id)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch broadcast snapshot"})
+		return
+	}
+	if snapshot != nil {
+		response["snapshot"] = snapshot
+	}
+
+	// ?user_id= answers whether a specific recipient got the notification
+	if userIDParam := ctx.Query("user_id"); userIDParam != "" {
+		userID, err := strconv.Atoi(userIDParam)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user_id"})
+			return
+		}
+		deliveries, err := n.deliveryRepository.GetUserDeliveries(ctx, id, userID)
+		if err != nil {
+			fmt.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch deliveries"})
+			return
+		}
+		response["deliveries"] = deliveries
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (n *notificationsService) ListNotifications(ctx *gin.Context) {
+	filter := models.NotificationListFilter{Limit: defaultListLimit}
+
+	if status := ctx.Query("status"); status != "" {
+		notificationStatus := models.NotificationStatus(status)
+		filter.Status = &notificationStatus
+	}
+
+	since, err := parseTimeQuery(ctx, "since")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Since = since
+
+	until, err := parseTimeQuery(ctx, "until")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.Until = until
+
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		parsed, err := strconv.Atoi(cursor)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		filter.Cursor = parsed
+	}
+
+	if limit := ctx.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		filter.Limit = min(parsed, maxListLimit)
+	}
+
+	notifications, err := n.notificationRepository.ListNotifications(ctx, filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not list notifications"})
+		return
+	}
+
+	// A full page means there may be more, the next page starts after the last id returned
+	var nextCursor *int
+	if len(notifications) == filter.Limit {
+		nextCursor = &notifications[len(notifications)-1].ID
+	}
+	if notifications == nil {
+		notifications = []models.Notification{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"notifications": notifications, "next_cursor": nextCursor})
+}
+
+func (n *notificationsService) GetNotificationByTaskID(ctx *gin.Context) {
+	notification, err := n.notificationRepository.GetNotificationByTransactionID(ctx, ctx.Param("task_id"))
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch notification"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"notification": notification})
+}
+
+func (n *notificationsService) CancelNotification(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid notification id"})
+		return
+	}
+
+	notification, err := n.notificationRepository.GetNotificationByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch notification"})
+		return
+	}
+	if notification.Status != models.NotificationStatusScheduled {
+		ctx.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("only scheduled notifications can be cancelled, this one is %s", notification.Status)})
+		return
+	}
+
+	// Not relayed yet, cancelling the outbox row keeps it out of asynq altogether
+	cancelled, err := n.outboxRepository.CancelPending(ctx, id)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not cancel notification"})
+		return
+	}
+
+	if !cancelled {
+		message, err := n.outboxRepository.GetMessageByNotificationID(ctx, id)
+		if err != nil {
+			fmt.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not find the task of the notification"})
+			return
+		}
+
+		// Only a task that is still waiting can be deleted, one that is running or done is too late to cancel
+		info, err := n.inspector.GetTaskInfo(message.Queue, message.TaskID)
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) ||
+			(err == nil && (info.State == asynq.TaskStateActive || info.State == asynq.TaskStateCompleted)) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": "the notification is already being sent"})
+			return
+		}
+		if err == nil {
+			err = n.inspector.DeleteTask(message.Queue, message.TaskID)
+		}
+		if err != nil {
+			fmt.Println(err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not cancel the task of the notification"})
+			return
+		}
+	}
+
+	err = n.notificationRepository.UpdateNotificationStatus(ctx, id, models.NotificationStatusCancelled)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "task cancelled, but the notification status could not be updated"})
+		return
+	}
+
+	log.Printf("cancelled scheduled notification %d", id)
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "notification_id": id, "status": models.NotificationStatusCancelled})
+}
+
+// parseTimeQuery reads an optional RFC3339 timestamp from the query string
+func parseTimeQuery(ctx *gin.Context, param string) (*time.Time, error) {
+	value := ctx.Query(param)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC3339 timestamp", param)
+	}
+	return &parsed, nil
+}
+
+func (n *notificationsService) GetAudience(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid notification id"})
+		return
+	}
+
+	snapshot, err := n.snapshotRepository.GetSnapshot(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no audience snapshot for this notification"})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch broadcast snapshot"})
+		return
+	}
+	// Its users are still being copied in, a page of them could be emptied again by a retry
+	if snapshot.CompletedAt == nil {
+		ctx.JSON(http.StatusConflict, gin.H{"error": "the audience snapshot is still being taken"})
+		return
+	}
+
+	after := 0
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		after, err = strconv.Atoi(cursor)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+	}
+
+	limit := defaultListLimit
+	if limitParam := ctx.Query("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = min(parsed, maxListLimit)
+	}
+
+	userIDs, err := n.snapshotRepository.GetSnapshotUserIDs(ctx, id, after, limit)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch audience"})
+		return
+	}
+
+	// Same as ListNotifications, a full page means there may be more
+	var nextCursor *int
+	if len(userIDs) == limit {
+		nextCursor = &userIDs[len(userIDs)-1]
+	}
+	if userIDs == nil {
+		userIDs = []int{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"snapshot": snapshot, "user_ids": userIDs, "next_cursor": nextCursor})
 }
@@ -1,8 +1,14 @@
 package service
 
 import (
+	"PingMeMaybe/gateway/pkg/service/cohorts"
+	"PingMeMaybe/gateway/pkg/service/lifecycle"
 	"PingMeMaybe/gateway/pkg/service/notifications"
+	"PingMeMaybe/gateway/pkg/service/suppressions"
+	"PingMeMaybe/gateway/pkg/service/templates"
+	"PingMeMaybe/libs/config"
 	"PingMeMaybe/libs/db"
+	"PingMeMaybe/libs/db/models"
 	"github.com/hibiken/asynq"
 )
 
@@ -12,18 +18,49 @@ import (
 
 type AppServices struct {
 	Notifications notifications.NotificationsServiceInterface
+	Templates     templates.TemplatesServiceInterface
+	Lifecycle     lifecycle.LifecycleServiceInterface
+	Cohorts       cohorts.CohortsServiceInterface
+	Suppressions  suppressions.SuppressionsServiceInterface
 }
 
 type AppServicesInterface interface {
 	NotificationsService() notifications.NotificationsServiceInterface
+	TemplatesService() templates.TemplatesServiceInterface
+	LifecycleService() lifecycle.LifecycleServiceInterface
+	CohortsService() cohorts.CohortsServiceInterface
+	SuppressionsService() suppressions.SuppressionsServiceInterface
 }
 
 func (a *AppServices) NotificationsService() notifications.NotificationsServiceInterface {
 	return a.Notifications
 }
 
-func InitAppServices(asynq *asynq.Client, dbService *db.DBService) AppServicesInterface {
+func (a *AppServices) TemplatesService() templates.TemplatesServiceInterface {
+	return a.Templates
+}
+
+func (a *AppServices) LifecycleService() lifecycle.LifecycleServiceInterface {
+	return a.Lifecycle
+}
+
+func (a *AppServices) CohortsService() cohorts.CohortsServiceInterface {
+	return a.Cohorts
+}
+
+func (a *AppServices) SuppressionsService() suppressions.SuppressionsServiceInterface {
+	return a.Suppressions
+}
+
+func InitAppServices(asynq *asynq.Client, inspector *asynq.Inspector, dbService *db.DBService) AppServicesInterface {
+	// The broadcast UI polls the cohort counts, which scan the whole users table
+	cachedCohorts := models.NewCachedUserCohortRepo(dbService.UserCohortsRepository(), config.GetRedisClient(), config.GetCohortCacheConfig().TTL)
+
 	return &AppServices{
-		Notifications: notifications.NewNotificationsService(asynq, dbService.NotificationsRepository()),
+		Notifications: notifications.NewNotificationsService(asynq, inspector, dbService.NotificationsRepository(), dbService.DeliveriesRepository(), dbService.OutboxRepository(), dbService.TemplatesRepository(), dbService.SavedCohortsRepository(), dbService.SnapshotsRepository(), config.GetDefaultLocale(), config.GetWebhookAllowlist()),
+		Templates:     templates.NewTemplatesService(dbService.TemplatesRepository(), config.GetDefaultLocale()),
+		Lifecycle:     lifecycle.NewLifecycleService(dbService.LifecycleRulesRepository(), dbService.SavedCohortsRepository()),
+		Cohorts:       cohorts.NewCohortsService(asynq, cachedCohorts, dbService.SavedCohortsRepository()),
+		Suppressions:  suppressions.NewSuppressionsService(dbService.SuppressionsRepository()),
 	}
 }
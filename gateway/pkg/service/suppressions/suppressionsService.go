@@ -0,0 +1,120 @@
+package suppressions
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/dto"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// Page size of GET /suppressions when no limit is given, and the largest limit accepted
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type suppressionsService struct {
+	suppressionRepository models.ISuppressionRepository
+}
+
+type SuppressionsServiceInterface interface {
+	AddSuppressions(ctx *gin.Context)   // Puts users on the global suppression list, broadcasts taking their snapshot from then on leave them out.
+	ListSuppressions(ctx *gin.Context)  // The suppression list by user id, cursor paginated.
+	RemoveSuppression(ctx *gin.Context) // Takes a user off the suppression list.
+}
+
+// Constructor
+func NewSuppressionsService(suppressionRepository models.ISuppressionRepository) SuppressionsServiceInterface {
+	return &suppressionsService{
+		suppressionRepository,
+	}
+}
+
+func (s *suppressionsService) AddSuppressions(ctx *gin.Context) {
+	var body dto.PostSuppressionsDTO
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	added, err := s.suppressionRepository.AddSuppressions(ctx, body.UserIDs, body.Reason)
+	if errors.Is(err, models.ErrSuppressionUnknownUser) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not add suppressions"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": true, "added": added})
+}
+
+func (s *suppressionsService) ListSuppressions(ctx *gin.Context) {
+	after := 0
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		parsed, err := strconv.Atoi(cursor)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		after = parsed
+	}
+
+	limit := defaultListLimit
+	if limitParam := ctx.Query("limit"); limitParam != "" {
+		parsed, err := strconv.Atoi(limitParam)
+		if err != nil || parsed <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		limit = min(parsed, maxListLimit)
+	}
+
+	suppressions, err := s.suppressionRepository.ListSuppressions(ctx, after, limit)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not list suppressions"})
+		return
+	}
+
+	// A full page means there may be more, the next page starts after the last user id returned
+	var nextCursor *int
+	if len(suppressions) == limit {
+		nextCursor = &suppressions[len(suppressions)-1].UserID
+	}
+	if suppressions == nil {
+		suppressions = []models.Suppression{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"suppressions": suppressions, "next_cursor": nextCursor})
+}
+
+func (s *suppressionsService) RemoveSuppression(ctx *gin.Context) {
+	userID, err := strconv.Atoi(ctx.Param("user_id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	removed, err := s.suppressionRepository.RemoveSuppression(ctx, userID)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not remove suppression"})
+		return
+	}
+	if !removed {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "user is not suppressed"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,229 @@
+package templates
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/dto"
+	"PingMeMaybe/libs/utils"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"net/http"
+	"strconv"
+)
+
+type templatesService struct {
+	templateRepository models.ITemplateRepository
+	// Locale every other locale falls back to, see config.GetDefaultLocale
+	defaultLocale string
+}
+
+type TemplatesServiceInterface interface {
+	CreateTemplate(ctx *gin.Context) // Saves a template and its variants, placeholders are checked against the known variables. A default locale variant is required.
+	ListTemplates(ctx *gin.Context)  // All templates with their variants, oldest first.
+	GetTemplate(ctx *gin.Context)    // A single template by id.
+	UpdateTemplate(ctx *gin.Context) // Renames a template and replaces its variants, queued notifications pick up the change.
+	DeleteTemplate(ctx *gin.Context) // Refused while lifecycle rules send it, notifications still using a deleted template fail to render.
+	PutVariant(ctx *gin.Context)     // Adds or replaces the variant of one locale.
+	DeleteVariant(ctx *gin.Context)  // Removes the variant of one locale, its users fall back to the next locale. The default locale's can only be replaced.
+}
+
+// Constructor
+func NewTemplatesService(templateRepository models.ITemplateRepository, defaultLocale string) TemplatesServiceInterface {
+	return &templatesService{
+		templateRepository,
+		defaultLocale,
+	}
+}
+
+func (t *templatesService) CreateTemplate(ctx *gin.Context) {
+	var body dto.PostTemplateDTO
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(t.defaultLocale); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template := body.ToTemplate()
+	id, err := t.templateRepository.CreateTemplate(ctx, template)
+	if errors.Is(err, models.ErrTemplateNameConflict) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not save template"})
+		return
+	}
+
+	template.ID = id
+	for i := range template.Variants {
+		template.Variants[i].TemplateID = id
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"template": template})
+}
+
+func (t *templatesService) ListTemplates(ctx *gin.Context) {
+	templates, err := t.templateRepository.ListTemplates(ctx)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not list templates"})
+		return
+	}
+	if templates == nil {
+		templates = []models.Template{}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+func (t *templatesService) GetTemplate(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid template id"})
+		return
+	}
+
+	template, err := t.templateRepository.GetTemplateByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not fetch template"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"template": template})
+}
+
+func (t *templatesService) UpdateTemplate(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid template id"})
+		return
+	}
+
+	var body dto.PostTemplateDTO
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(t.defaultLocale); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	template := body.ToTemplate()
+	template.ID = id
+	for i := range template.Variants {
+		template.Variants[i].TemplateID = id
+	}
+	err = t.templateRepository.UpdateTemplate(ctx, template)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+		return
+	}
+	if errors.Is(err, models.ErrTemplateNameConflict) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not update template"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"template": template})
+}
+
+func (t *templatesService) DeleteTemplate(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid template id"})
+		return
+	}
+
+	deleted, err := t.templateRepository.DeleteTemplate(ctx, id)
+	if errors.Is(err, models.ErrTemplateInUse) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete template"})
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+func (t *templatesService) PutVariant(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid template id"})
+		return
+	}
+
+	var body dto.PostTemplateVariantDTO
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	body.Locale = ctx.Param("locale")
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	variant := body.ToVariant()
+	variant.TemplateID = id
+	err = t.templateRepository.UpsertVariant(ctx, variant)
+	if errors.Is(err, pgx.ErrNoRows) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "template not found"})
+		return
+	}
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not save variant"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"variant": variant})
+}
+
+func (t *templatesService) DeleteVariant(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid template id"})
+		return
+	}
+
+	// Every locale falls back to the default one, without it their users would get nothing
+	locale := utils.NormalizeLocale(ctx.Param("locale"))
+	if locale == t.defaultLocale {
+		ctx.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("the variant of the default locale %s can't be deleted, replace it instead", locale)})
+		return
+	}
+
+	deleted, err := t.templateRepository.DeleteVariant(ctx, id, locale)
+	if err != nil {
+		fmt.Println(err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "could not delete variant"})
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "variant not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"success": true})
+}
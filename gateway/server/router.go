@@ -10,11 +10,47 @@ import (
 
 func SetRoutes(r *gin.Engine, dbConn *pgxpool.Pool) *gin.Engine {
 	asynqClient := config.GetAsynqClient()
+	asynqInspector := config.GetAsynqInspector()
 	dbService := db.NewDBService(dbConn)
 
-	services := service.InitAppServices(asynqClient, dbService)
+	services := service.InitAppServices(asynqClient, asynqInspector, dbService)
 
 	r.POST("/notification", services.NotificationsService().QueueNotification)
+	r.POST("/broadcast", services.NotificationsService().QueueBulkBroadcast)
+	r.GET("/notifications", services.NotificationsService().ListNotifications)
+	r.GET("/notifications/:id", services.NotificationsService().GetNotification)
+	r.GET("/notifications/:id/audience", services.NotificationsService().GetAudience)
+	r.GET("/notifications/by-task/:task_id", services.NotificationsService().GetNotificationByTaskID)
+	r.DELETE("/notifications/:id", services.NotificationsService().CancelNotification)
+
+	r.POST("/templates", services.TemplatesService().CreateTemplate)
+	r.GET("/templates", services.TemplatesService().ListTemplates)
+	r.GET("/templates/:id", services.TemplatesService().GetTemplate)
+	r.PUT("/templates/:id", services.TemplatesService().UpdateTemplate)
+	r.DELETE("/templates/:id", services.TemplatesService().DeleteTemplate)
+	r.PUT("/templates/:id/variants/:locale", services.TemplatesService().PutVariant)
+	r.DELETE("/templates/:id/variants/:locale", services.TemplatesService().DeleteVariant)
+
+	r.POST("/lifecycle-rules", services.LifecycleService().CreateRule)
+	r.GET("/lifecycle-rules", services.LifecycleService().ListRules)
+	r.GET("/lifecycle-rules/:id", services.LifecycleService().GetRule)
+	r.PUT("/lifecycle-rules/:id", services.LifecycleService().UpdateRule)
+	r.DELETE("/lifecycle-rules/:id", services.LifecycleService().DeleteRule)
+
+	r.GET("/cohorts/stats", services.CohortsService().GetCohortStats)
+	r.GET("/cohorts/:type/count", services.CohortsService().GetCohortCount)
+	r.POST("/cohorts/preview", services.CohortsService().GetUserCohorts)
+	r.DELETE("/cohorts/cache", services.CohortsService().InvalidateCache)
+
+	r.POST("/cohorts/saved", services.CohortsService().CreateSavedCohort)
+	r.GET("/cohorts/saved", services.CohortsService().ListSavedCohorts)
+	r.GET("/cohorts/saved/:id", services.CohortsService().GetSavedCohort)
+	r.PUT("/cohorts/saved/:id", services.CohortsService().UpdateSavedCohort)
+	r.DELETE("/cohorts/saved/:id", services.CohortsService().DeleteSavedCohort)
+
+	r.POST("/suppressions", services.SuppressionsService().AddSuppressions)
+	r.GET("/suppressions", services.SuppressionsService().ListSuppressions)
+	r.DELETE("/suppressions/:user_id", services.SuppressionsService().RemoveSuppression)
 
 	return r
 }
@@ -14,6 +14,5 @@ func StartServer(db *pgxpool.Pool) {
 	err := serverWithRoutes.Run(":8080")
 	if err != nil {
 		panic(err)
-		return
 	}
 }
@@ -1,6 +1,9 @@
 package config
 
-import "github.com/hibiken/asynq"
+import (
+	"PingMeMaybe/libs/messagePatterns"
+	"github.com/hibiken/asynq"
+)
 
 func GetAsynqClient() *asynq.Client {
 	LoadEnv(".")
@@ -11,3 +14,35 @@ func GetAsynqClient() *asynq.Client {
 		Password: GetConfig().GetString("REDIS_PASSWORD"),
 	})
 }
+
+// GetAsynqInspector is used to look into and manage tasks that are already queued
+func GetAsynqInspector() *asynq.Inspector {
+	LoadEnv(".")
+
+	return asynq.NewInspector(asynq.RedisClientOpt{
+		Addr:     GetConfig().GetString("REDIS_CLUSTER"),
+		Username: GetConfig().GetString("REDIS_USERNAME"),
+		Password: GetConfig().GetString("REDIS_PASSWORD"),
+	})
+}
+
+// GetAsynqServerConfig returns the processor's worker settings. Concurrency and the queue weights
+// can be tuned with ASYNQ_CONCURRENCY and ASYNQ_QUEUE_{CRITICAL,DEFAULT,LOW}_WEIGHT.
+func GetAsynqServerConfig() asynq.Config {
+	LoadEnv(".")
+
+	GetConfig().SetDefault("ASYNQ_CONCURRENCY", 10)
+	GetConfig().SetDefault("ASYNQ_QUEUE_CRITICAL_WEIGHT", 6)
+	GetConfig().SetDefault("ASYNQ_QUEUE_DEFAULT_WEIGHT", 3)
+	GetConfig().SetDefault("ASYNQ_QUEUE_LOW_WEIGHT", 1)
+
+	return asynq.Config{
+		Concurrency: GetConfig().GetInt("ASYNQ_CONCURRENCY"),
+		// Priorities
+		Queues: map[string]int{
+			messagePatterns.QueueCritical: GetConfig().GetInt("ASYNQ_QUEUE_CRITICAL_WEIGHT"),
+			messagePatterns.QueueDefault:  GetConfig().GetInt("ASYNQ_QUEUE_DEFAULT_WEIGHT"),
+			messagePatterns.QueueLow:      GetConfig().GetInt("ASYNQ_QUEUE_LOW_WEIGHT"),
+		},
+	}
+}
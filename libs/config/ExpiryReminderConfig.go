@@ -0,0 +1,45 @@
+package config
+
+import (
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type ExpiryReminderConfig struct {
+	// Cron spec of the reminder run
+	Schedule string
+	// Days before the subscription ends at which users are reminded, largest first
+	Thresholds []int
+	// Optional, 0 sends the built-in text on the default channel
+	TemplateID int
+	ChannelID  int
+}
+
+func GetExpiryReminderConfig() ExpiryReminderConfig {
+	LoadEnv(".")
+
+	GetConfig().SetDefault("EXPIRY_REMINDER_SCHEDULE", "@daily")
+	GetConfig().SetDefault("EXPIRY_REMINDER_THRESHOLDS", "30,7,1")
+
+	// Comma separated, e.g. 30,7,1. A reminder expires when the subscription ends, so 0 days before it can never be sent.
+	// Reminders go to the premium_near_expiry cohort, which ends 30 days out.
+	var thresholds []int
+	for _, part := range strings.Split(GetConfig().GetString("EXPIRY_REMINDER_THRESHOLDS"), ",") {
+		days, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || days < 1 || days > 30 {
+			log.Fatalf("invalid EXPIRY_REMINDER_THRESHOLDS entry %q, thresholds are whole days from 1 to 30", part)
+		}
+		thresholds = append(thresholds, days)
+	}
+	slices.Sort(thresholds)
+	slices.Reverse(thresholds)
+
+	return ExpiryReminderConfig{
+		Schedule:   GetConfig().GetString("EXPIRY_REMINDER_SCHEDULE"),
+		Thresholds: slices.Compact(thresholds),
+		TemplateID: GetConfig().GetInt("EXPIRY_REMINDER_TEMPLATE_ID"),
+		ChannelID:  GetConfig().GetInt("EXPIRY_REMINDER_CHANNEL_ID"),
+	}
+}
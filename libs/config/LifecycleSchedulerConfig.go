@@ -0,0 +1,16 @@
+package config
+
+type LifecycleSchedulerConfig struct {
+	// Cron spec of the rule evaluation, each rule's cooldown decides how often a user hears from it
+	Schedule string
+}
+
+func GetLifecycleSchedulerConfig() LifecycleSchedulerConfig {
+	LoadEnv(".")
+
+	GetConfig().SetDefault("LIFECYCLE_SCHEDULE", "@every 15m")
+
+	return LifecycleSchedulerConfig{
+		Schedule: GetConfig().GetString("LIFECYCLE_SCHEDULE"),
+	}
+}
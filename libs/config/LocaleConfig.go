@@ -0,0 +1,12 @@
+package config
+
+import "PingMeMaybe/libs/utils"
+
+// GetDefaultLocale returns the locale templates fall back to when a user's locale has no variant,
+// every template sent as a broadcast needs a variant for it
+func GetDefaultLocale() string {
+	LoadEnv(".")
+
+	GetConfig().SetDefault("DEFAULT_LOCALE", "en")
+	return utils.NormalizeLocale(GetConfig().GetString("DEFAULT_LOCALE"))
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// GetRedisClient connects to the same Redis as Asynq, for caching
+func GetRedisClient() *redis.Client {
+	LoadEnv(".")
+
+	return redis.NewClient(&redis.Options{
+		Addr:     GetConfig().GetString("REDIS_CLUSTER"),
+		Username: GetConfig().GetString("REDIS_USERNAME"),
+		Password: GetConfig().GetString("REDIS_PASSWORD"),
+	})
+}
+
+type CohortCacheConfig struct {
+	// How long cohort stats and counts are served from the cache, ?fresh=true skips it
+	TTL time.Duration
+}
+
+func GetCohortCacheConfig() CohortCacheConfig {
+	LoadEnv(".")
+
+	GetConfig().SetDefault("COHORT_CACHE_TTL", "5m")
+
+	return CohortCacheConfig{
+		TTL: GetConfig().GetDuration("COHORT_CACHE_TTL"),
+	}
+}
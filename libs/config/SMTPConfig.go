@@ -0,0 +1,28 @@
+package config
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Sender address, may include a display name
+	From string
+}
+
+// GetSMTPConfig reads the email channel's SMTP settings. The defaults point at a local
+// SMTP sink such as Mailpit or MailHog, which accept mail on port 1025 without auth.
+func GetSMTPConfig() SMTPConfig {
+	LoadEnv(".")
+
+	GetConfig().SetDefault("SMTP_HOST", "localhost")
+	GetConfig().SetDefault("SMTP_PORT", 1025)
+	GetConfig().SetDefault("SMTP_FROM", "PingMeMaybe <no-reply@pingmemaybe.local>")
+
+	return SMTPConfig{
+		Host:     GetConfig().GetString("SMTP_HOST"),
+		Port:     GetConfig().GetInt("SMTP_PORT"),
+		Username: GetConfig().GetString("SMTP_USERNAME"),
+		Password: GetConfig().GetString("SMTP_PASSWORD"),
+		From:     GetConfig().GetString("SMTP_FROM"),
+	}
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type WebhookConfig struct {
+	// Key of the HMAC-SHA256 signature sent with every webhook
+	SigningSecret string
+	Timeout       time.Duration
+	// Used when a notification doesn't name its own webhook_url
+	DefaultURL string
+	// Hosts a notification's own webhook_url may point at
+	AllowedHosts WebhookAllowlist
+}
+
+// WebhookAllowlist is the hosts the API accepts in webhook_url, entries like "*.example.com" match any subdomain.
+// Webhooks carry the recipient's details, so a caller can't send them anywhere it likes. Empty allows no host.
+type WebhookAllowlist []string
+
+// Allows reports whether the url is http(s) and its host is on the allowlist
+func (a WebhookAllowlist) Allows(rawURL string) bool {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "" {
+		return false
+	}
+	for _, allowed := range a {
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// GetWebhookAllowlist reads WEBHOOK_ALLOWED_HOSTS, comma separated
+func GetWebhookAllowlist() WebhookAllowlist {
+	LoadEnv(".")
+
+	var allowlist WebhookAllowlist
+	for _, part := range strings.Split(GetConfig().GetString("WEBHOOK_ALLOWED_HOSTS"), ",") {
+		if host := strings.ToLower(strings.TrimSpace(part)); host != "" {
+			allowlist = append(allowlist, host)
+		}
+	}
+	return allowlist
+}
+
+func GetWebhookConfig() WebhookConfig {
+	LoadEnv(".")
+
+	GetConfig().SetDefault("WEBHOOK_TIMEOUT", "10s")
+
+	// Signing with an empty key would look fine to consumers that don't check, and prove nothing.
+	// Without a secret the webhook channel refuses to send, the other channels work as usual.
+	secret := GetConfig().GetString("WEBHOOK_SIGNING_SECRET")
+	if secret == "" {
+		log.Println("WEBHOOK_SIGNING_SECRET is not set, webhook notifications will fail")
+	}
+
+	return WebhookConfig{
+		SigningSecret: secret,
+		Timeout:       GetConfig().GetDuration("WEBHOOK_TIMEOUT"),
+		DefaultURL:    GetConfig().GetString("WEBHOOK_DEFAULT_URL"),
+		AllowedHosts:  GetWebhookAllowlist(),
+	}
+}
@@ -8,13 +8,31 @@ import (
 // Service that just interfaces all DB model services from one place
 
 type DBService struct {
-	Notifications models.INotificationRepository
-	UserCohorts   models.IUserCohortRepository
+	Notifications   models.INotificationRepository
+	UserCohorts     models.IUserCohortRepository
+	BroadcastChunks models.IBroadcastChunkRepository
+	Deliveries      models.IDeliveryRepository
+	Outbox          models.IOutboxRepository
+	Templates       models.ITemplateRepository
+	ExpiryReminders models.IExpiryReminderRepository
+	LifecycleRules  models.ILifecycleRuleRepository
+	SavedCohorts    models.ISavedCohortRepository
+	Snapshots       models.IBroadcastSnapshotRepository
+	Suppressions    models.ISuppressionRepository
 }
 
 type DBServiceInterface interface {
 	NotificationsRepository() models.INotificationRepository
 	UserCohortsRepository() models.IUserCohortRepository
+	BroadcastChunksRepository() models.IBroadcastChunkRepository
+	DeliveriesRepository() models.IDeliveryRepository
+	OutboxRepository() models.IOutboxRepository
+	TemplatesRepository() models.ITemplateRepository
+	ExpiryRemindersRepository() models.IExpiryReminderRepository
+	LifecycleRulesRepository() models.ILifecycleRuleRepository
+	SavedCohortsRepository() models.ISavedCohortRepository
+	SnapshotsRepository() models.IBroadcastSnapshotRepository
+	SuppressionsRepository() models.ISuppressionRepository
 }
 
 func (this DBService) NotificationsRepository() models.INotificationRepository {
@@ -25,9 +43,54 @@ func (this DBService) UserCohortsRepository() models.IUserCohortRepository {
 	return this.UserCohorts
 }
 
+func (this DBService) BroadcastChunksRepository() models.IBroadcastChunkRepository {
+	return this.BroadcastChunks
+}
+
+func (this DBService) DeliveriesRepository() models.IDeliveryRepository {
+	return this.Deliveries
+}
+
+func (this DBService) OutboxRepository() models.IOutboxRepository {
+	return this.Outbox
+}
+
+func (this DBService) TemplatesRepository() models.ITemplateRepository {
+	return this.Templates
+}
+
+func (this DBService) ExpiryRemindersRepository() models.IExpiryReminderRepository {
+	return this.ExpiryReminders
+}
+
+func (this DBService) LifecycleRulesRepository() models.ILifecycleRuleRepository {
+	return this.LifecycleRules
+}
+
+func (this DBService) SavedCohortsRepository() models.ISavedCohortRepository {
+	return this.SavedCohorts
+}
+
+func (this DBService) SnapshotsRepository() models.IBroadcastSnapshotRepository {
+	return this.Snapshots
+}
+
+func (this DBService) SuppressionsRepository() models.ISuppressionRepository {
+	return this.Suppressions
+}
+
 func NewDBService(db *pgxpool.Pool) *DBService {
 	return &DBService{
-		Notifications: models.NewNotificationRepo(db),
-		UserCohorts:   models.NewUserCohortRepo(db),
+		Notifications:   models.NewNotificationRepo(db),
+		UserCohorts:     models.NewUserCohortRepo(db),
+		BroadcastChunks: models.NewBroadcastChunkRepo(db),
+		Deliveries:      models.NewDeliveryRepo(db),
+		Outbox:          models.NewOutboxRepo(db),
+		Templates:       models.NewTemplateRepo(db),
+		ExpiryReminders: models.NewExpiryReminderRepo(db),
+		LifecycleRules:  models.NewLifecycleRuleRepo(db),
+		SavedCohorts:    models.NewSavedCohortRepo(db),
+		Snapshots:       models.NewBroadcastSnapshotRepo(db),
+		Suppressions:    models.NewSuppressionRepo(db),
 	}
 }
@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+type BroadcastChunk struct {
+	ID             int `json:"id"`
+	NotificationID int `json:"notification_id"`
+	StartUserID    int `json:"start_user_id"`
+	EndUserID      int `json:"end_user_id"`
+	// Last user id that was dispatched, nil until the first page of the chunk is done
+	CheckpointUserID *int                 `json:"checkpoint_user_id"`
+	DispatchedCount  int                  `json:"dispatched_count"`
+	Status           BroadcastChunkStatus `json:"status"`
+	CreatedAt        time.Time            `json:"created_at"`
+	UpdatedAt        time.Time            `json:"updated_at"`
+}
+
+type BroadcastChunkStatus string
+
+const (
+	BroadcastChunkStatusPending    BroadcastChunkStatus = "PENDING"
+	BroadcastChunkStatusProcessing BroadcastChunkStatus = "PROCESSING"
+	BroadcastChunkStatusCompleted  BroadcastChunkStatus = "COMPLETED"
+)
+
+// ResumeAfterUserID is the id a chunk task should continue from, users are fetched with id > ResumeAfterUserID
+func (c BroadcastChunk) ResumeAfterUserID() int {
+	if c.CheckpointUserID != nil {
+		return *c.CheckpointUserID
+	}
+	return c.StartUserID - 1
+}
+
+type BroadcastChunkRepo struct {
+	DB *pgxpool.Pool
+}
+
+type IBroadcastChunkRepository interface {
+	CreateChunks(ctx context.Context, chunks []BroadcastChunk) error
+	GetChunkByID(ctx context.Context, id int) (*BroadcastChunk, error)
+	GetChunksByNotification(ctx context.Context, notificationID int) ([]BroadcastChunk, error)
+	SaveCheckpoint(ctx context.Context, id int, checkpointUserID int, dispatched int) error
+	MarkChunkCompleted(ctx context.Context, id int) error
+	CountIncompleteChunks(ctx context.Context, notificationID int) (int, error)
+}
+
+func NewBroadcastChunkRepo(db *pgxpool.Pool) IBroadcastChunkRepository {
+	return &BroadcastChunkRepo{
+		DB: db,
+	}
+}
+
+// CreateChunks inserts the chunks of a broadcast, chunks that already exist are left untouched
+// so that a retried broadcast keeps the progress of its chunks
+func (r *BroadcastChunkRepo) CreateChunks(ctx context.Context, chunks []BroadcastChunk) error {
+	query := `INSERT INTO broadcast_chunks (notification_id, start_user_id, end_user_id, status)
+			  VALUES ($1, $2, $3, $4) ON CONFLICT (notification_id, start_user_id) DO NOTHING`
+
+	batch := &pgx.Batch{}
+	for _, chunk := range chunks {
+		batch.Queue(query, chunk.NotificationID, chunk.StartUserID, chunk.EndUserID, BroadcastChunkStatusPending)
+	}
+
+	err := r.DB.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return fmt.Errorf("failed to create broadcast chunks: %w", err)
+	}
+	return nil
+}
+
+func (r *BroadcastChunkRepo) GetChunkByID(ctx context.Context, id int) (*BroadcastChunk, error) {
+	query := `SELECT id, notification_id, start_user_id, end_user_id, checkpoint_user_id, dispatched_count, status, created_at, updated_at
+			  FROM broadcast_chunks WHERE id = $1`
+
+	var chunk BroadcastChunk
+	err := r.DB.QueryRow(ctx, query, id).Scan(
+		&chunk.ID,
+		&chunk.NotificationID,
+		&chunk.StartUserID,
+		&chunk.EndUserID,
+		&chunk.CheckpointUserID,
+		&chunk.DispatchedCount,
+		&chunk.Status,
+		&chunk.CreatedAt,
+		&chunk.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get broadcast chunk %d: %w", id, err)
+	}
+	return &chunk, nil
+}
+
+func (r *BroadcastChunkRepo) GetChunksByNotification(ctx context.Context, notificationID int) ([]BroadcastChunk, error) {
+	query := `SELECT id, notification_id, start_user_id, end_user_id, checkpoint_user_id, dispatched_count, status, created_at, updated_at
+			  FROM broadcast_chunks WHERE notification_id = $1 ORDER BY start_user_id`
+
+	rows, err := r.DB.Query(ctx, query, notificationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get broadcast chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var chunks []BroadcastChunk
+	for rows.Next() {
+		var chunk BroadcastChunk
+		err := rows.Scan(
+			&chunk.ID,
+			&chunk.NotificationID,
+			&chunk.StartUserID,
+			&chunk.EndUserID,
+			&chunk.CheckpointUserID,
+			&chunk.DispatchedCount,
+			&chunk.Status,
+			&chunk.CreatedAt,
+			&chunk.UpdatedAt,
+		)
+		if err != nil {
+			fmt.Printf("Error scanning broadcast chunk: %v\n", err)
+			continue
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, rows.Err()
+}
+
+// SaveCheckpoint records the last user id dispatched by a chunk and adds to its dispatched count
+func (r *BroadcastChunkRepo) SaveCheckpoint(ctx context.Context, id int, checkpointUserID int, dispatched int) error {
+	query := `UPDATE broadcast_chunks
+			  SET checkpoint_user_id = $1, dispatched_count = dispatched_count + $2, status = $3, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $4`
+	_, err := r.DB.Exec(ctx, query, checkpointUserID, dispatched, BroadcastChunkStatusProcessing, id)
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint of broadcast chunk %d: %w", id, err)
+	}
+	return nil
+}
+
+func (r *BroadcastChunkRepo) MarkChunkCompleted(ctx context.Context, id int) error {
+	query := `UPDATE broadcast_chunks SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+	_, err := r.DB.Exec(ctx, query, BroadcastChunkStatusCompleted, id)
+	if err != nil {
+		return fmt.Errorf("failed to complete broadcast chunk %d: %w", id, err)
+	}
+	return nil
+}
+
+func (r *BroadcastChunkRepo) CountIncompleteChunks(ctx context.Context, notificationID int) (int, error) {
+	query := `SELECT COUNT(*) FROM broadcast_chunks WHERE notification_id = $1 AND status <> $2`
+
+	var count int
+	err := r.DB.QueryRow(ctx, query, notificationID, BroadcastChunkStatusCompleted).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count incomplete broadcast chunks: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"fmt"
+)
+
+// Longest look-back of BroadcastExclusions.RecentlyMessagedHours, a week
+const maxRecentlyMessagedHours = 7 * 24
+
+// BroadcastExclusions leave users out of the cohort of a broadcast.
+// The global suppression list is always applied on top of them.
+type BroadcastExclusions struct {
+	// Users in any of these cohorts are left out
+	Cohorts []ExcludedCohort `json:"cohorts,omitempty"`
+	// Users who were successfully sent any notification in the last this many hours are left out, 0 doesn't check
+	RecentlyMessagedHours int `json:"recently_messaged_hours,omitempty"`
+}
+
+// ExcludedCohort is a cohort defined the same way as the audience of a broadcast,
+// the empty cohort type is all active users narrowed down by the filters
+type ExcludedCohort struct {
+	CohortType UserCohortType `json:"cohort_type"`
+	Filters    *CohortFilters `json:"filters,omitempty"`
+}
+
+func (e *BroadcastExclusions) Validate() error {
+	if e == nil {
+		return nil
+	}
+	for i, cohort := range e.Cohorts {
+		if cohort.CohortType == "" && cohort.Filters == nil {
+			return fmt.Errorf("exclusions.cohorts[%d]: a cohort type or filters are required, excluding every user leaves nobody to send to", i)
+		}
+		if cohort.CohortType != "" && !cohort.CohortType.IsValid() {
+			return fmt.Errorf("exclusions.cohorts[%d]: unknown cohort type: %s", i, cohort.CohortType)
+		}
+		if err := cohort.Filters.Validate(); err != nil {
+			return fmt.Errorf("exclusions.cohorts[%d]: %w", i, err)
+		}
+	}
+	if e.RecentlyMessagedHours < 0 || e.RecentlyMessagedHours > maxRecentlyMessagedHours {
+		return fmt.Errorf("exclusions.recently_messaged_hours must be between 0 and %d", maxRecentlyMessagedHours)
+	}
+	return nil
+}
+
+// ExclusionCounts is how many users of the cohort each exclusion removed from a broadcast.
+// A user matching several exclusions is counted once, against the first one in the order of the fields.
+type ExclusionCounts struct {
+	SuppressionList int `json:"suppression_list"`
+	// One count per entry of BroadcastExclusions.Cohorts, in the same order
+	Cohorts          []int `json:"cohorts"`
+	RecentlyMessaged int   `json:"recently_messaged"`
+}
+
+// Total is the number of users removed by all exclusions together
+func (c ExclusionCounts) Total() int {
+	total := c.SuppressionList + c.RecentlyMessaged
+	for _, count := range c.Cohorts {
+		total += count
+	}
+	return total
+}
+
+func (c *ExclusionCounts) add(other ExclusionCounts) {
+	c.SuppressionList += other.SuppressionList
+	c.RecentlyMessaged += other.RecentlyMessaged
+	if len(c.Cohorts) < len(other.Cohorts) {
+		c.Cohorts = append(c.Cohorts, make([]int, len(other.Cohorts)-len(c.Cohorts))...)
+	}
+	for i, count := range other.Cohorts {
+		c.Cohorts[i] += count
+	}
+}
+
+// SnapshotFilter removes the excluded users from a batch of a broadcast's cohort, and counts what it removed
+type SnapshotFilter func(ctx context.Context, batch []UserCohort) ([]UserCohort, ExclusionCounts, error)
+
+// NewSnapshotFilter returns the filter applying the exclusions and the suppression list.
+// Each rule is one query over the users of the batch that are still left.
+func NewSnapshotFilter(exclusions *BroadcastExclusions, suppressions ISuppressionRepository, cohorts IUserCohortRepository, deliveries IDeliveryRepository) SnapshotFilter {
+	if exclusions == nil {
+		exclusions = &BroadcastExclusions{}
+	}
+
+	return func(ctx context.Context, batch []UserCohort) ([]UserCohort, ExclusionCounts, error) {
+		counts := ExclusionCounts{Cohorts: make([]int, len(exclusions.Cohorts))}
+
+		// remove drops the users whose ids are in excluded and returns how many it dropped
+		remove := func(excluded []int) int {
+			if len(excluded) == 0 {
+				return 0
+			}
+			set := make(map[int]bool, len(excluded))
+			for _, id := range excluded {
+				set[id] = true
+			}
+			kept := make([]UserCohort, 0, len(batch))
+			for _, user := range batch {
+				if !set[user.UserID] {
+					kept = append(kept, user)
+				}
+			}
+			removed := len(batch) - len(kept)
+			batch = kept
+			return removed
+		}
+		ids := func() []int {
+			userIDs := make([]int, len(batch))
+			for i, user := range batch {
+				userIDs[i] = user.UserID
+			}
+			return userIDs
+		}
+
+		suppressed, err := suppressions.GetSuppressedUserIDs(ctx, ids())
+		if err != nil {
+			return nil, counts, err
+		}
+		counts.SuppressionList = remove(suppressed)
+
+		for i, cohort := range exclusions.Cohorts {
+			if len(batch) == 0 {
+				break
+			}
+			members, err := cohorts.FilterCohortUserIDs(ctx, cohort.CohortType, cohort.Filters, ids())
+			if err != nil {
+				return nil, counts, fmt.Errorf("excluded cohort %d: %w", i, err)
+			}
+			counts.Cohorts[i] = remove(members)
+		}
+
+		if exclusions.RecentlyMessagedHours > 0 && len(batch) > 0 {
+			messaged, err := deliveries.GetRecentlyDeliveredUserIDs(ctx, ids(), exclusions.RecentlyMessagedHours)
+			if err != nil {
+				return nil, counts, err
+			}
+			counts.RecentlyMessaged = remove(messaged)
+		}
+
+		return batch, counts, nil
+	}
+}
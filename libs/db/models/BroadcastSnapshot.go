@@ -0,0 +1,178 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"iter"
+	"time"
+)
+
+// BroadcastSnapshot is the audience of a broadcast, frozen when its fan-out starts
+type BroadcastSnapshot struct {
+	NotificationID int            `json:"notification_id"`
+	CohortType     UserCohortType `json:"cohort_type"`
+	Filters        *CohortFilters `json:"filters"`
+	// The exclusions the snapshot was taken with, and how many users of the cohort each of them left out
+	Exclusions *BroadcastExclusions `json:"exclusions,omitempty"`
+	Excluded   ExclusionCounts      `json:"excluded"`
+	// Users left after the exclusions
+	UserCount int       `json:"user_count"`
+	CreatedAt time.Time `json:"created_at"`
+	// Set once every user is copied in, the audience of a snapshot without it is still being taken
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+type BroadcastSnapshotRepo struct {
+	DB *pgxpool.Pool
+}
+
+type IBroadcastSnapshotRepository interface {
+	// CreateSnapshot saves the users as the audience of a broadcast, copying every batch in as it is yielded
+	// once filter removed the excluded users from it. What filter removed is recorded in the snapshot's Excluded.
+	// Every batch is committed on its own, so a big cohort doesn't hold a transaction open for the whole copy, and
+	// the snapshot is marked complete after the last one. A broadcast has one snapshot, if it already has a complete
+	// one (a retried task) that one is returned and users is not read. An incomplete one is emptied and taken again.
+	CreateSnapshot(ctx context.Context, snapshot BroadcastSnapshot, users iter.Seq2[[]UserCohort, error], filter SnapshotFilter) (*BroadcastSnapshot, error)
+	GetSnapshot(ctx context.Context, notificationID int) (*BroadcastSnapshot, error)
+	// GetSnapshotUserIDRange returns the lowest and highest user id in a snapshot, both are 0 for an empty one
+	GetSnapshotUserIDRange(ctx context.Context, notificationID int) (int, int, error)
+	// GetSnapshotUsersInIDRange returns up to limit users of a snapshot with afterUserID < id <= untilUserID, ordered by
+	// id, with the users' current details. Paging by id lets a caller checkpoint the last id it handled and resume.
+	GetSnapshotUsersInIDRange(ctx context.Context, notificationID int, cohortType UserCohortType, afterUserID int, untilUserID int, limit int) ([]UserCohort, error)
+	// GetSnapshotUserIDs pages through the ids of the users in a snapshot, in ascending order
+	GetSnapshotUserIDs(ctx context.Context, notificationID int, afterUserID int, limit int) ([]int, error)
+}
+
+func NewBroadcastSnapshotRepo(db *pgxpool.Pool) IBroadcastSnapshotRepository {
+	return &BroadcastSnapshotRepo{
+		DB: db,
+	}
+}
+
+func (r *BroadcastSnapshotRepo) CreateSnapshot(ctx context.Context, snapshot BroadcastSnapshot, users iter.Seq2[[]UserCohort, error], filter SnapshotFilter) (*BroadcastSnapshot, error) {
+	tag, err := r.DB.Exec(ctx, `INSERT INTO broadcast_snapshots (notification_id, cohort_type, filters, exclusions) VALUES ($1, $2, $3, $4)
+			  ON CONFLICT (notification_id) DO NOTHING`,
+		snapshot.NotificationID, snapshot.CohortType, snapshot.Filters, snapshot.Exclusions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create broadcast snapshot: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		existing, err := r.GetSnapshot(ctx, snapshot.NotificationID)
+		if err != nil {
+			return nil, err
+		}
+		if existing.CompletedAt != nil {
+			return existing, nil
+		}
+
+		// An earlier attempt stopped part way, the cohort is streamed again from the start
+		_, err = r.DB.Exec(ctx, `DELETE FROM broadcast_snapshot_users WHERE notification_id = $1`, snapshot.NotificationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to clear incomplete broadcast snapshot: %w", err)
+		}
+	}
+
+	snapshot.Excluded = ExclusionCounts{}
+	if snapshot.Exclusions != nil {
+		snapshot.Excluded.Cohorts = make([]int, len(snapshot.Exclusions.Cohorts))
+	}
+	for batch, err := range users {
+		if err != nil {
+			return nil, err
+		}
+
+		batch, excluded, err := filter(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply broadcast exclusions: %w", err)
+		}
+		snapshot.Excluded.add(excluded)
+		if len(batch) == 0 {
+			continue
+		}
+
+		rows := make([][]interface{}, len(batch))
+		for i, user := range batch {
+			rows[i] = []interface{}{snapshot.NotificationID, user.UserID}
+		}
+		_, err = r.DB.CopyFrom(ctx, pgx.Identifier{"broadcast_snapshot_users"}, []string{"notification_id", "user_id"}, pgx.CopyFromRows(rows))
+		if err != nil {
+			return nil, fmt.Errorf("failed to copy broadcast snapshot users: %w", err)
+		}
+	}
+
+	// Counted from the table rather than the batches, so the count is what the chunks will read
+	query := `UPDATE broadcast_snapshots
+			  SET user_count = (SELECT COUNT(*) FROM broadcast_snapshot_users WHERE notification_id = $1),
+			      excluded = $2, completed_at = CURRENT_TIMESTAMP
+			  WHERE notification_id = $1
+			  RETURNING user_count, created_at, completed_at`
+	err = r.DB.QueryRow(ctx, query, snapshot.NotificationID, snapshot.Excluded).Scan(&snapshot.UserCount, &snapshot.CreatedAt, &snapshot.CompletedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete broadcast snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+func (r *BroadcastSnapshotRepo) GetSnapshot(ctx context.Context, notificationID int) (*BroadcastSnapshot, error) {
+	query := `SELECT notification_id, cohort_type, filters, exclusions, excluded, user_count, created_at, completed_at FROM broadcast_snapshots WHERE notification_id = $1`
+
+	var snapshot BroadcastSnapshot
+	err := r.DB.QueryRow(ctx, query, notificationID).Scan(
+		&snapshot.NotificationID,
+		&snapshot.CohortType,
+		&snapshot.Filters,
+		&snapshot.Exclusions,
+		&snapshot.Excluded,
+		&snapshot.UserCount,
+		&snapshot.CreatedAt,
+		&snapshot.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+func (r *BroadcastSnapshotRepo) GetSnapshotUserIDRange(ctx context.Context, notificationID int) (int, int, error) {
+	query := `SELECT COALESCE(MIN(user_id), 0), COALESCE(MAX(user_id), 0) FROM broadcast_snapshot_users WHERE notification_id = $1`
+
+	var minID, maxID int
+	err := r.DB.QueryRow(ctx, query, notificationID).Scan(&minID, &maxID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get snapshot id range: %w", err)
+	}
+	return minID, maxID, nil
+}
+
+func (r *BroadcastSnapshotRepo) GetSnapshotUsersInIDRange(ctx context.Context, notificationID int, cohortType UserCohortType, afterUserID int, untilUserID int, limit int) ([]UserCohort, error) {
+	// Membership is frozen, but users deactivated since the snapshot are still left out
+	query := `SELECT ` + cohortUserColumns + `
+			  FROM broadcast_snapshot_users s
+			  JOIN users u ON u.id = s.user_id
+			  WHERE s.notification_id = $1 AND s.user_id > $2 AND s.user_id <= $3 AND u.is_active = true
+			  ORDER BY s.user_id ASC LIMIT $4`
+
+	rows, err := r.DB.Query(ctx, query, notificationID, afterUserID, untilUserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshot users in id range: %w", err)
+	}
+	defer rows.Close()
+
+	return scanCohortUsers(rows, cohortType)
+}
+
+func (r *BroadcastSnapshotRepo) GetSnapshotUserIDs(ctx context.Context, notificationID int, afterUserID int, limit int) ([]int, error) {
+	query := `SELECT user_id FROM broadcast_snapshot_users
+			  WHERE notification_id = $1 AND user_id > $2
+			  ORDER BY user_id ASC LIMIT $3`
+
+	rows, err := r.DB.Query(ctx, query, notificationID, afterUserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query snapshot user ids: %w", err)
+	}
+	defer rows.Close()
+
+	return scanUserIDs(rows)
+}
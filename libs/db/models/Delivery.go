@@ -0,0 +1,214 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// Delivery is the state of a notification for a single recipient on a single channel
+type Delivery struct {
+	ID             int            `json:"id"`
+	NotificationID int            `json:"notification_id"`
+	UserID         int            `json:"user_id"`
+	Channel        string         `json:"channel"`
+	Status         DeliveryStatus `json:"status"`
+	Attempts       int            `json:"attempts"`
+	Error          *string        `json:"error"`
+	CreatedAt      time.Time      `json:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending  DeliveryStatus = "PENDING"
+	DeliveryStatusSuccess  DeliveryStatus = "SUCCESS"
+	DeliveryStatusRetrying DeliveryStatus = "RETRYING"
+	DeliveryStatusFailed   DeliveryStatus = "FAILED"
+	// The user opted out of the channel in their notification preferences, not a failure
+	DeliveryStatusSkippedPreference DeliveryStatus = "SKIPPED_PREFERENCE"
+)
+
+// Channel recorded for deliveries that were not routed to a specific channel
+const DefaultDeliveryChannel = "default"
+
+type DeliveryStats struct {
+	Status DeliveryStatus `json:"status"`
+	Count  int            `json:"count"`
+}
+
+type DeliveryRepo struct {
+	DB *pgxpool.Pool
+}
+
+type IDeliveryRepository interface {
+	CreateDeliveries(ctx context.Context, deliveries []Delivery) error
+	RecordAttempt(ctx context.Context, notificationID int, userID int, channel string, status DeliveryStatus, deliveryErr error) error
+	GetDeliveriesByNotification(ctx context.Context, notificationID int) ([]Delivery, error)
+	GetDeliveriesByUser(ctx context.Context, userID int) ([]Delivery, error)
+	GetUserDeliveries(ctx context.Context, notificationID int, userID int) ([]Delivery, error)
+	GetDeliveryStats(ctx context.Context, notificationID int) ([]DeliveryStats, error)
+	// GetRecentlyDeliveredUserIDs returns which of the users were successfully sent any notification in the last withinHours hours
+	GetRecentlyDeliveredUserIDs(ctx context.Context, userIDs []int, withinHours int) ([]int, error)
+}
+
+func NewDeliveryRepo(db *pgxpool.Pool) IDeliveryRepository {
+	return &DeliveryRepo{
+		DB: db,
+	}
+}
+
+const deliveryColumns = `id, notification_id, user_id, channel, status, attempts, error, created_at, updated_at, delivered_at`
+
+// CreateDeliveries inserts pending deliveries, existing ones are kept as they are
+func (r *DeliveryRepo) CreateDeliveries(ctx context.Context, deliveries []Delivery) error {
+	query := `INSERT INTO notification_deliveries (notification_id, user_id, channel, status)
+			  VALUES ($1, $2, $3, $4) ON CONFLICT (notification_id, user_id, channel) DO NOTHING`
+
+	batch := &pgx.Batch{}
+	for _, d := range deliveries {
+		status := d.Status
+		if status == "" {
+			status = DeliveryStatusPending
+		}
+		batch.Queue(query, d.NotificationID, d.UserID, d.Channel, status)
+	}
+
+	err := r.DB.SendBatch(ctx, batch).Close()
+	if err != nil {
+		return fmt.Errorf("failed to create deliveries: %w", err)
+	}
+	return nil
+}
+
+// RecordAttempt counts one send attempt of a delivery and stores its outcome, creating the delivery if needed
+func (r *DeliveryRepo) RecordAttempt(ctx context.Context, notificationID int, userID int, channel string, status DeliveryStatus, deliveryErr error) error {
+	var errMsg *string
+	if deliveryErr != nil {
+		msg := deliveryErr.Error()
+		errMsg = &msg
+	}
+	var deliveredAt *time.Time
+	if status == DeliveryStatusSuccess {
+		now := time.Now()
+		deliveredAt = &now
+	}
+
+	query := `INSERT INTO notification_deliveries (notification_id, user_id, channel, status, attempts, error, delivered_at)
+			  VALUES ($1, $2, $3, $4, 1, $5, $6)
+			  ON CONFLICT (notification_id, user_id, channel) DO UPDATE SET
+				  status = EXCLUDED.status,
+				  attempts = notification_deliveries.attempts + 1,
+				  error = EXCLUDED.error,
+				  delivered_at = COALESCE(EXCLUDED.delivered_at, notification_deliveries.delivered_at),
+				  updated_at = CURRENT_TIMESTAMP`
+	_, err := r.DB.Exec(ctx, query, notificationID, userID, channel, status, errMsg, deliveredAt)
+	if err != nil {
+		return fmt.Errorf("failed to record delivery attempt: %w", err)
+	}
+	return nil
+}
+
+func (r *DeliveryRepo) GetDeliveriesByNotification(ctx context.Context, notificationID int) ([]Delivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM notification_deliveries WHERE notification_id = $1 ORDER BY id`
+
+	rows, err := r.DB.Query(ctx, query, notificationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries of notification %d: %w", notificationID, err)
+	}
+	defer rows.Close()
+
+	return scanDeliveries(rows)
+}
+
+func (r *DeliveryRepo) GetDeliveriesByUser(ctx context.Context, userID int) ([]Delivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM notification_deliveries WHERE user_id = $1 ORDER BY id DESC`
+
+	rows, err := r.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	return scanDeliveries(rows)
+}
+
+// GetUserDeliveries returns the deliveries of a notification to one user, one per channel it was sent on
+func (r *DeliveryRepo) GetUserDeliveries(ctx context.Context, notificationID int, userID int) ([]Delivery, error) {
+	query := `SELECT ` + deliveryColumns + ` FROM notification_deliveries WHERE notification_id = $1 AND user_id = $2 ORDER BY id`
+
+	rows, err := r.DB.Query(ctx, query, notificationID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deliveries of notification %d to user %d: %w", notificationID, userID, err)
+	}
+	defer rows.Close()
+
+	return scanDeliveries(rows)
+}
+
+func (r *DeliveryRepo) GetDeliveryStats(ctx context.Context, notificationID int) ([]DeliveryStats, error) {
+	query := `SELECT status, COUNT(*) FROM notification_deliveries WHERE notification_id = $1 GROUP BY status ORDER BY 2 DESC`
+
+	rows, err := r.DB.Query(ctx, query, notificationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []DeliveryStats
+	for rows.Next() {
+		var stat DeliveryStats
+		if err := rows.Scan(&stat.Status, &stat.Count); err != nil {
+			fmt.Printf("Error scanning delivery stats: %v\n", err)
+			continue
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats, rows.Err()
+}
+
+func scanDeliveries(rows pgx.Rows) ([]Delivery, error) {
+	var deliveries []Delivery
+	for rows.Next() {
+		var d Delivery
+		err := rows.Scan(
+			&d.ID,
+			&d.NotificationID,
+			&d.UserID,
+			&d.Channel,
+			&d.Status,
+			&d.Attempts,
+			&d.Error,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+			&d.DeliveredAt,
+		)
+		if err != nil {
+			fmt.Printf("Error scanning delivery: %v\n", err)
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (r *DeliveryRepo) GetRecentlyDeliveredUserIDs(ctx context.Context, userIDs []int, withinHours int) ([]int, error) {
+	// delivered_at is written from the processor's clock, compared in Go time so both sides agree
+	since := time.Now().Add(-time.Duration(withinHours) * time.Hour)
+	query := `SELECT DISTINCT user_id FROM notification_deliveries
+			  WHERE user_id = ANY($1) AND status = $2 AND delivered_at >= $3`
+
+	rows, err := r.DB.Query(ctx, query, userIDs, DeliveryStatusSuccess, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recently delivered users: %w", err)
+	}
+	defer rows.Close()
+
+	return scanUserIDs(rows)
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// ExpiryReminder records that a user was reminded their subscription expires within ThresholdDays
+type ExpiryReminder struct {
+	ID              int       `json:"id"`
+	UserID          int       `json:"user_id"`
+	ThresholdDays   int       `json:"threshold_days"`
+	SubscriptionEnd time.Time `json:"subscription_end_date"`
+	NotificationID  *int      `json:"notification_id"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+type ExpiryReminderRepo struct {
+	DB *pgxpool.Pool
+}
+
+type IExpiryReminderRepository interface {
+	// CreateReminder records the reminder and saves the notification that delivers it, with its outbox message, in one transaction.
+	// It returns 0 without saving anything when the user was already reminded for the threshold of this subscription period.
+	CreateReminder(ctx context.Context, reminder ExpiryReminder, notification Notification, message OutboxMessage, payload func(notificationID int) ([]byte, error)) (int, error)
+	GetRemindersByUser(ctx context.Context, userID int) ([]ExpiryReminder, error)
+}
+
+func NewExpiryReminderRepo(db *pgxpool.Pool) IExpiryReminderRepository {
+	return &ExpiryReminderRepo{
+		DB: db,
+	}
+}
+
+func (r *ExpiryReminderRepo) CreateReminder(ctx context.Context, reminder ExpiryReminder, notification Notification, message OutboxMessage, payload func(notificationID int) ([]byte, error)) (int, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Claiming the reminder first keeps concurrent runs from both sending it
+	var reminderID int
+	query := `INSERT INTO expiry_reminders (user_id, threshold_days, subscription_end_date) 
+			  VALUES ($1, $2, $3)
+			  ON CONFLICT (user_id, threshold_days, subscription_end_date) DO NOTHING
+			  RETURNING id`
+	err = tx.QueryRow(ctx, query, reminder.UserID, reminder.ThresholdDays, reminder.SubscriptionEnd).Scan(&reminderID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to record expiry reminder: %w", err)
+	}
+
+	notificationID, err := insertNotificationWithOutbox(ctx, tx, notification, message, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE expiry_reminders SET notification_id = $1 WHERE id = $2`, notificationID, reminderID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to link expiry reminder: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit expiry reminder: %w", err)
+	}
+	return reminderID, nil
+}
+
+func (r *ExpiryReminderRepo) GetRemindersByUser(ctx context.Context, userID int) ([]ExpiryReminder, error) {
+	query := `SELECT id, user_id, threshold_days, subscription_end_date, notification_id, created_at 
+			  FROM expiry_reminders WHERE user_id = $1 ORDER BY created_at DESC`
+
+	rows, err := r.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch expiry reminders: %w", err)
+	}
+	defer rows.Close()
+
+	var reminders []ExpiryReminder
+	for rows.Next() {
+		var e ExpiryReminder
+		err := rows.Scan(&e.ID, &e.UserID, &e.ThresholdDays, &e.SubscriptionEnd, &e.NotificationID, &e.CreatedAt)
+		if err != nil {
+			fmt.Printf("Error scanning expiry reminder: %v\n", err)
+			continue
+		}
+		reminders = append(reminders, e)
+	}
+
+	return reminders, rows.Err()
+}
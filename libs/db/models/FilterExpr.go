@@ -0,0 +1,400 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FilterExpr is a small JSON filter language over users, compiled to parameterized SQL.
+// Exactly one of And, Or, Not, Field, Pref or Cohort is set:
+//
+//	{"and": [...]}, {"or": [...]}, {"not": {...}}
+//	{"field": "subscription_tier", "op": "in", "value": ["pro", "enterprise"]}
+//	{"field": "subscription_end_date", "op": "within", "value": "30d"}
+//	{"pref": "email", "op": "eq", "value": true}
+//	{"cohort": "PREMIUM_NEAR_EXPIRY"}
+//
+// Fields are the columns in filterFields, values are checked against the column type.
+type FilterExpr struct {
+	And   []FilterExpr `json:"and,omitempty"`
+	Or    []FilterExpr `json:"or,omitempty"`
+	Not   *FilterExpr  `json:"not,omitempty"`
+	Field string       `json:"field,omitempty"`
+	// Key of users.notification_preferences, missing keys count as their DefaultNotificationPreferences value
+	Pref string `json:"pref,omitempty"`
+	// Users whose primary cohort, see ClassifyUser, is this built-in cohort
+	Cohort UserCohortType `json:"cohort,omitempty"`
+	Op     FilterOp       `json:"op,omitempty"`
+	Value  interface{}    `json:"value,omitempty"`
+}
+
+type FilterOp string
+
+const (
+	FilterOpEq      FilterOp = "eq"
+	FilterOpNeq     FilterOp = "neq"
+	FilterOpLt      FilterOp = "lt"
+	FilterOpLte     FilterOp = "lte"
+	FilterOpGt      FilterOp = "gt"
+	FilterOpGte     FilterOp = "gte"
+	FilterOpIn      FilterOp = "in"
+	FilterOpNotIn   FilterOp = "not_in"
+	FilterOpIsNull  FilterOp = "is_null"
+	FilterOpNotNull FilterOp = "not_null"
+
+	// Date-relative ops on timestamp fields, the value is an offset like "30d", "12h" or "2w".
+	// within and before/after are relative to the current date, within_last and older_than to the current time.
+
+	// CURRENT_DATE < field <= CURRENT_DATE + offset, e.g. subscriptions ending in the next 30 days
+	FilterOpWithin FilterOp = "within"
+	// CURRENT_TIMESTAMP - offset <= field <= CURRENT_TIMESTAMP, e.g. logged in this week
+	FilterOpWithinLast FilterOp = "within_last"
+	// field < CURRENT_TIMESTAMP - offset, e.g. no login for 30 days
+	FilterOpOlderThan FilterOp = "older_than"
+	// field < CURRENT_DATE + offset, the offset can be negative ("-7d") and "0d" is today
+	FilterOpBefore FilterOp = "before"
+	// field > CURRENT_DATE + offset
+	FilterOpAfter FilterOp = "after"
+)
+
+type filterFieldType int
+
+const (
+	filterString filterFieldType = iota
+	filterInt
+	filterBool
+	filterTime
+)
+
+// filterFields are the user columns a FilterExpr can refer to
+var filterFields = map[string]filterFieldType{
+	"id":                      filterInt,
+	"email":                   filterString,
+	"username":                filterString,
+	"first_name":              filterString,
+	"last_name":               filterString,
+	"is_premium_user":         filterBool,
+	"subscription_tier":       filterString,
+	"subscription_start_date": filterTime,
+	"subscription_end_date":   filterTime,
+	"timezone":                filterString,
+	"locale":                  filterString,
+	"created_at":              filterTime,
+	"updated_at":              filterTime,
+	"last_login_at":           filterTime,
+	"is_active":               filterBool,
+}
+
+var comparisonOperators = map[FilterOp]string{
+	FilterOpEq:  "=",
+	FilterOpNeq: "<>",
+	FilterOpLt:  "<",
+	FilterOpLte: "<=",
+	FilterOpGt:  ">",
+	FilterOpGte: ">=",
+}
+
+// Builders for filters written in Go, like the built-in cohorts
+
+func AllOf(exprs ...FilterExpr) FilterExpr {
+	return FilterExpr{And: exprs}
+}
+
+func AnyOf(exprs ...FilterExpr) FilterExpr {
+	return FilterExpr{Or: exprs}
+}
+
+func Negate(expr FilterExpr) FilterExpr {
+	return FilterExpr{Not: &expr}
+}
+
+func FieldFilter(field string, op FilterOp, value interface{}) FilterExpr {
+	return FilterExpr{Field: field, Op: op, Value: value}
+}
+
+func CohortFilter(cohortType UserCohortType) FilterExpr {
+	return FilterExpr{Cohort: cohortType}
+}
+
+// Validate reports whether the expression compiles
+func (e FilterExpr) Validate() error {
+	_, _, err := e.Compile(1)
+	return err
+}
+
+// Compile returns the expression as a SQL condition over users aliased u, with its args numbered from firstArg
+func (e FilterExpr) Compile(firstArg int) (string, []interface{}, error) {
+	c := &filterCompiler{nextArg: firstArg}
+	sql, err := c.compile(e)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, c.args, nil
+}
+
+type filterCompiler struct {
+	nextArg int
+	args    []interface{}
+}
+
+func (c *filterCompiler) arg(value interface{}) string {
+	c.args = append(c.args, value)
+	c.nextArg++
+	return fmt.Sprintf("$%d", c.nextArg-1)
+}
+
+func (c *filterCompiler) compile(e FilterExpr) (string, error) {
+	set := 0
+	for _, isSet := range []bool{len(e.And) > 0, len(e.Or) > 0, e.Not != nil, e.Field != "", e.Pref != "", e.Cohort != ""} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return "", fmt.Errorf("a filter needs exactly one of and, or, not, field, pref or cohort")
+	}
+
+	switch {
+	case len(e.And) > 0:
+		return c.compileList(e.And, " AND ")
+	case len(e.Or) > 0:
+		return c.compileList(e.Or, " OR ")
+	case e.Not != nil:
+		inner, err := c.compile(*e.Not)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	case e.Pref != "":
+		return c.compilePref(e)
+	case e.Cohort != "":
+		return c.compileCohort(e)
+	}
+	return c.compileField(e)
+}
+
+func (c *filterCompiler) compileList(exprs []FilterExpr, separator string) (string, error) {
+	if len(exprs) == 0 {
+		return "", fmt.Errorf("and and or need at least one filter")
+	}
+	parts := make([]string, len(exprs))
+	for i, expr := range exprs {
+		part, err := c.compile(expr)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = part
+	}
+	return "(" + strings.Join(parts, separator) + ")", nil
+}
+
+func (c *filterCompiler) compileField(e FilterExpr) (string, error) {
+	fieldType, ok := filterFields[e.Field]
+	if !ok {
+		return "", fmt.Errorf("unknown filter field %q", e.Field)
+	}
+	column := "u." + e.Field
+
+	switch e.Op {
+	case FilterOpIsNull:
+		return "(" + column + " IS NULL)", nil
+	case FilterOpNotNull:
+		return "(" + column + " IS NOT NULL)", nil
+
+	case FilterOpEq, FilterOpNeq, FilterOpLt, FilterOpLte, FilterOpGt, FilterOpGte:
+		if fieldType == filterBool && e.Op != FilterOpEq && e.Op != FilterOpNeq {
+			return "", fmt.Errorf("%s can't be used on the boolean field %s", e.Op, e.Field)
+		}
+		value, err := filterValue(e.Field, fieldType, e.Value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s %s %s)", column, comparisonOperators[e.Op], c.arg(value)), nil
+
+	case FilterOpIn, FilterOpNotIn:
+		list, ok := e.Value.([]interface{})
+		if !ok {
+			return "", fmt.Errorf("%s on %s needs a list value", e.Op, e.Field)
+		}
+		values, err := filterListValue(e.Field, fieldType, list)
+		if err != nil {
+			return "", err
+		}
+		if e.Op == FilterOpNotIn {
+			return fmt.Sprintf("(%s <> ALL(%s))", column, c.arg(values)), nil
+		}
+		return fmt.Sprintf("(%s = ANY(%s))", column, c.arg(values)), nil
+
+	case FilterOpWithin, FilterOpWithinLast, FilterOpOlderThan, FilterOpBefore, FilterOpAfter:
+		if fieldType != filterTime {
+			return "", fmt.Errorf("%s needs a date field, %s is not one", e.Op, e.Field)
+		}
+		hours, err := parseFilterOffset(e.Value)
+		if err != nil {
+			return "", fmt.Errorf("%s on %s: %w", e.Op, e.Field, err)
+		}
+		offset := "make_interval(hours => " + c.arg(hours) + ")"
+
+		switch e.Op {
+		case FilterOpWithin:
+			return fmt.Sprintf("(%s > CURRENT_DATE AND %s <= CURRENT_DATE + %s)", column, column, offset), nil
+		case FilterOpWithinLast:
+			return fmt.Sprintf("(%s >= CURRENT_TIMESTAMP - %s AND %s <= CURRENT_TIMESTAMP)", column, offset, column), nil
+		case FilterOpOlderThan:
+			return fmt.Sprintf("(%s < CURRENT_TIMESTAMP - %s)", column, offset), nil
+		case FilterOpBefore:
+			return fmt.Sprintf("(%s < CURRENT_DATE + %s)", column, offset), nil
+		default:
+			return fmt.Sprintf("(%s > CURRENT_DATE + %s)", column, offset), nil
+		}
+	}
+	return "", fmt.Errorf("unknown filter op %q on %s", e.Op, e.Field)
+}
+
+func (c *filterCompiler) compilePref(e FilterExpr) (string, error) {
+	defaultValue, ok := DefaultNotificationPreferences().Lookup(e.Pref)
+	if !ok {
+		return "", fmt.Errorf("unknown notification preference %q", e.Pref)
+	}
+	if e.Op != FilterOpEq && e.Op != FilterOpNeq {
+		return "", fmt.Errorf("notification preferences only support eq and neq, not %q", e.Op)
+	}
+	value, ok := e.Value.(bool)
+	if !ok {
+		return "", fmt.Errorf("notification preference %s needs a boolean value", e.Pref)
+	}
+
+	preference := fmt.Sprintf("COALESCE((u.notification_preferences->>%s::text)::boolean, %s)", c.arg(e.Pref), c.arg(defaultValue))
+	return fmt.Sprintf("(%s %s %s)", preference, comparisonOperators[e.Op], c.arg(value)), nil
+}
+
+func (c *filterCompiler) compileCohort(e FilterExpr) (string, error) {
+	if !e.Cohort.IsValid() {
+		return "", fmt.Errorf("unknown cohort type: %s", e.Cohort)
+	}
+	if e.Op != "" || e.Value != nil {
+		return "", fmt.Errorf("cohort filters take no op or value")
+	}
+
+	classification, err := c.compileClassification()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s = %s)", classification, c.arg(string(e.Cohort))), nil
+}
+
+// compileClassification returns the CASE that classifies a user into their primary cohort, following cohortRules
+func (c *filterCompiler) compileClassification() (string, error) {
+	classification := "CASE"
+	for _, rule := range cohortRules {
+		condition, err := c.compile(rule.Rule)
+		if err != nil {
+			return "", fmt.Errorf("invalid definition of cohort %s: %w", rule.CohortType, err)
+		}
+		classification += fmt.Sprintf(" WHEN %s THEN %s", condition, c.arg(string(rule.CohortType)))
+	}
+	return classification + " ELSE 'OTHER' END", nil
+}
+
+// classificationSQL returns the CASE classifying users aliased u into their primary cohort, with its args numbered from firstArg
+func classificationSQL(firstArg int) (string, []interface{}, error) {
+	c := &filterCompiler{nextArg: firstArg}
+	sql, err := c.compileClassification()
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, c.args, nil
+}
+
+var filterOffsetPattern = regexp.MustCompile(`^(-?\d+)([hdw])$`)
+
+// parseFilterOffset turns an offset like "30d" into hours
+func parseFilterOffset(value interface{}) (int, error) {
+	text, ok := value.(string)
+	if !ok {
+		return 0, fmt.Errorf("the value must be an offset like \"30d\", \"12h\" or \"2w\"")
+	}
+	match := filterOffsetPattern.FindStringSubmatch(strings.TrimSpace(text))
+	if match == nil {
+		return 0, fmt.Errorf("invalid offset %q, expected something like \"30d\", \"12h\" or \"2w\"", text)
+	}
+	amount, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid offset %q: %w", text, err)
+	}
+	switch match[2] {
+	case "d":
+		return amount * 24, nil
+	case "w":
+		return amount * 24 * 7, nil
+	}
+	return amount, nil
+}
+
+// filterValue converts a json value to the go type of the field, so pgx encodes it as the column type.
+// Values built in go (like the built-in cohorts) are accepted as they are.
+func filterValue(field string, fieldType filterFieldType, value interface{}) (interface{}, error) {
+	switch fieldType {
+	case filterString:
+		if v, ok := value.(string); ok {
+			return v, nil
+		}
+	case filterBool:
+		if v, ok := value.(bool); ok {
+			return v, nil
+		}
+	case filterInt:
+		switch v := value.(type) {
+		case float64:
+			if v == float64(int64(v)) {
+				return int64(v), nil
+			}
+		case int:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		}
+	case filterTime:
+		switch v := value.(type) {
+		case time.Time:
+			return v, nil
+		case string:
+			for _, layout := range []string{time.RFC3339, time.DateOnly} {
+				if parsed, err := time.Parse(layout, v); err == nil {
+					return parsed, nil
+				}
+			}
+			return nil, fmt.Errorf("%s needs an RFC3339 time or a YYYY-MM-DD date, got %q", field, v)
+		}
+	}
+	return nil, fmt.Errorf("invalid value %v for %s", value, field)
+}
+
+func filterListValue(field string, fieldType filterFieldType, list []interface{}) (interface{}, error) {
+	switch fieldType {
+	case filterString:
+		values := make([]string, len(list))
+		for i, item := range list {
+			v, err := filterValue(field, fieldType, item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v.(string)
+		}
+		return values, nil
+	case filterInt:
+		values := make([]int64, len(list))
+		for i, item := range list {
+			v, err := filterValue(field, fieldType, item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v.(int64)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("in and not_in are only supported on text and integer fields, not %s", field)
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseFilter reads a filter the way the API does, so numbers arrive as float64
+func parseFilter(t *testing.T, text string) FilterExpr {
+	t.Helper()
+	var expr FilterExpr
+	if err := json.Unmarshal([]byte(text), &expr); err != nil {
+		t.Fatalf("invalid filter %s: %v", text, err)
+	}
+	return expr
+}
+
+func TestFilterExprValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantErr string
+	}{
+		{"field", `{"field": "subscription_tier", "op": "eq", "value": "pro"}`, ""},
+		{"nested", `{"and": [{"not": {"field": "last_login_at", "op": "is_null"}}, {"or": [{"pref": "email", "op": "eq", "value": true}, {"cohort": "ACTIVE_PREMIUM"}]}]}`, ""},
+		{"empty", `{}`, "exactly one of"},
+		{"two kinds", `{"field": "email", "op": "is_null", "pref": "email"}`, "exactly one of"},
+		{"unknown field", `{"field": "password", "op": "eq", "value": "x"}`, `unknown filter field "password"`},
+		{"unknown op", `{"field": "email", "op": "like", "value": "x"}`, `unknown filter op "like"`},
+		{"ordering a boolean", `{"field": "is_premium_user", "op": "gt", "value": true}`, "can't be used on the boolean field"},
+		{"date op on text", `{"field": "email", "op": "within", "value": "30d"}`, "needs a date field"},
+		{"bad offset unit", `{"field": "last_login_at", "op": "older_than", "value": "30m"}`, `invalid offset "30m"`},
+		{"offset not text", `{"field": "last_login_at", "op": "older_than", "value": 30}`, "must be an offset"},
+		{"wrong value type", `{"field": "id", "op": "eq", "value": "12"}`, "invalid value 12 for id"},
+		{"fractional id", `{"field": "id", "op": "eq", "value": 1.5}`, "invalid value 1.5 for id"},
+		{"bad date", `{"field": "created_at", "op": "gt", "value": "yesterday"}`, "needs an RFC3339 time"},
+		{"in without list", `{"field": "locale", "op": "in", "value": "en"}`, "needs a list value"},
+		{"in on a date", `{"field": "created_at", "op": "in", "value": ["2025-01-01"]}`, "only supported on text and integer fields"},
+		{"unknown pref", `{"pref": "fax", "op": "eq", "value": true}`, `unknown notification preference "fax"`},
+		{"ordering a pref", `{"pref": "email", "op": "lt", "value": true}`, "only support eq and neq"},
+		{"pref not boolean", `{"pref": "email", "op": "eq", "value": "yes"}`, "needs a boolean value"},
+		{"unknown cohort", `{"cohort": "VIP"}`, "unknown cohort type: VIP"},
+		{"cohort with op", `{"cohort": "NON_PREMIUM", "op": "eq"}`, "take no op or value"},
+		{"invalid inside not", `{"not": {"field": "password", "op": "is_null"}}`, "unknown filter field"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := parseFilter(t, tt.filter).Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterExprCompile(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   string
+		firstArg int
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{"eq", `{"field": "subscription_tier", "op": "eq", "value": "pro"}`, 1,
+			"(u.subscription_tier = $1)", []interface{}{"pro"}},
+		{"numbered from firstArg", `{"field": "id", "op": "gte", "value": 100}`, 4,
+			"(u.id >= $4)", []interface{}{int64(100)}},
+		{"date", `{"field": "created_at", "op": "lt", "value": "2025-01-02"}`, 1,
+			"(u.created_at < $1)", []interface{}{time.Date(2025, time.January, 2, 0, 0, 0, 0, time.UTC)}},
+		{"is_null", `{"field": "last_login_at", "op": "is_null"}`, 1,
+			"(u.last_login_at IS NULL)", nil},
+		{"in", `{"field": "locale", "op": "in", "value": ["en", "de"]}`, 1,
+			"(u.locale = ANY($1))", []interface{}{[]string{"en", "de"}}},
+		{"not_in", `{"field": "id", "op": "not_in", "value": [1, 2]}`, 1,
+			"(u.id <> ALL($1))", []interface{}{[]int64{1, 2}}},
+		{"within", `{"field": "subscription_end_date", "op": "within", "value": "2w"}`, 1,
+			"(u.subscription_end_date > CURRENT_DATE AND u.subscription_end_date <= CURRENT_DATE + make_interval(hours => $1))", []interface{}{336}},
+		{"within_last", `{"field": "last_login_at", "op": "within_last", "value": "12h"}`, 1,
+			"(u.last_login_at >= CURRENT_TIMESTAMP - make_interval(hours => $1) AND u.last_login_at <= CURRENT_TIMESTAMP)", []interface{}{12}},
+		{"older_than", `{"field": "last_login_at", "op": "older_than", "value": "30d"}`, 1,
+			"(u.last_login_at < CURRENT_TIMESTAMP - make_interval(hours => $1))", []interface{}{720}},
+		{"before a negative offset", `{"field": "subscription_end_date", "op": "before", "value": "-7d"}`, 1,
+			"(u.subscription_end_date < CURRENT_DATE + make_interval(hours => $1))", []interface{}{-168}},
+		{"after", `{"field": "subscription_end_date", "op": "after", "value": "0d"}`, 1,
+			"(u.subscription_end_date > CURRENT_DATE + make_interval(hours => $1))", []interface{}{0}},
+		{"pref", `{"pref": "sms", "op": "neq", "value": true}`, 1,
+			"(COALESCE((u.notification_preferences->>$1::text)::boolean, $2) <> $3)", []interface{}{"sms", false, true}},
+		{"and, or and not", `{"and": [{"field": "is_premium_user", "op": "eq", "value": true}, {"or": [{"not": {"field": "last_login_at", "op": "is_null"}}, {"field": "id", "op": "lt", "value": 10}]}]}`, 2,
+			"((u.is_premium_user = $2) AND (NOT (u.last_login_at IS NULL) OR (u.id < $3)))", []interface{}{true, int64(10)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := parseFilter(t, tt.filter).Compile(tt.firstArg)
+			if err != nil {
+				t.Fatalf("Compile: %v", err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %s\nwant  %s", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestFilterExprMatches(t *testing.T) {
+	now := time.Date(2026, time.March, 15, 10, 0, 0, 0, time.UTC)
+	at := func(days int, hour int) *time.Time {
+		t := time.Date(2026, time.March, 15+days, hour, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	// Never logged in and no subscription dates, so every comparison on those is unknown
+	neverLoggedIn := UserCohort{
+		UserID:            7,
+		IsPremiumUser:     true,
+		SubscriptionTier:  "pro",
+		Locale:            "de",
+		NotificationPrefs: NotificationPreferences{Email: false, Push: true},
+		CreatedAt:         *at(-40, 9),
+	}
+	loggedIn := neverLoggedIn
+	loggedIn.LastLoginAt = at(-3, 12)
+	loggedIn.SubscriptionEnd = at(5, 0)
+
+	tests := []struct {
+		name   string
+		user   UserCohort
+		filter string
+		want   bool
+	}{
+		{"eq", neverLoggedIn, `{"field": "subscription_tier", "op": "eq", "value": "pro"}`, true},
+		{"in", neverLoggedIn, `{"field": "locale", "op": "in", "value": ["en", "de"]}`, true},
+		{"not_in", neverLoggedIn, `{"field": "id", "op": "not_in", "value": [7, 8]}`, false},
+		{"is_null", neverLoggedIn, `{"field": "last_login_at", "op": "is_null"}`, true},
+		{"not_null", loggedIn, `{"field": "last_login_at", "op": "not_null"}`, true},
+
+		{"comparison on NULL", neverLoggedIn, `{"field": "last_login_at", "op": "older_than", "value": "1d"}`, false},
+		{"negated comparison on NULL", neverLoggedIn, `{"not": {"field": "last_login_at", "op": "older_than", "value": "1d"}}`, false},
+		{"NULL or true", neverLoggedIn, `{"or": [{"field": "last_login_at", "op": "older_than", "value": "1d"}, {"field": "locale", "op": "eq", "value": "de"}]}`, true},
+		{"not (NULL or false)", neverLoggedIn, `{"not": {"or": [{"field": "last_login_at", "op": "older_than", "value": "1d"}, {"field": "locale", "op": "eq", "value": "en"}]}}`, false},
+		{"not (NULL and false)", neverLoggedIn, `{"not": {"and": [{"field": "last_login_at", "op": "older_than", "value": "1d"}, {"field": "locale", "op": "eq", "value": "en"}]}}`, true},
+		{"not false", neverLoggedIn, `{"not": {"field": "locale", "op": "eq", "value": "en"}}`, true},
+
+		{"older_than", loggedIn, `{"field": "last_login_at", "op": "older_than", "value": "2d"}`, true},
+		{"within_last", loggedIn, `{"field": "last_login_at", "op": "within_last", "value": "1w"}`, true},
+		{"within", loggedIn, `{"field": "subscription_end_date", "op": "within", "value": "5d"}`, true},
+		{"within, a day short", loggedIn, `{"field": "subscription_end_date", "op": "within", "value": "4d"}`, false},
+		{"before", loggedIn, `{"field": "subscription_end_date", "op": "before", "value": "6d"}`, true},
+		{"after, compared to the date", loggedIn, `{"field": "subscription_end_date", "op": "after", "value": "5d"}`, false},
+
+		{"pref set", neverLoggedIn, `{"pref": "email", "op": "eq", "value": false}`, true},
+		{"pref default", neverLoggedIn, `{"pref": "sms", "op": "eq", "value": false}`, true},
+		{"cohort", loggedIn, `{"cohort": "PREMIUM_NEAR_EXPIRY"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFilter(t, tt.filter).Matches(tt.user, now)
+			if err != nil {
+				t.Fatalf("Matches: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterExprMatchesErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		wantErr string
+	}{
+		{"invalid filter", `{"field": "password", "op": "is_null"}`, "unknown filter field"},
+		{"SQL only field", `{"field": "is_active", "op": "eq", "value": true}`, "can only be filtered on in SQL"},
+	}
+
+	for _, tt := range tests {
+		_, err := parseFilter(t, tt.filter).Matches(UserCohort{}, time.Now())
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: Matches error = %v, want one containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// filterTruth is a SQL boolean, comparisons on NULL are unknown
+type filterTruth int
+
+const (
+	filterUnknown filterTruth = iota
+	filterFalse
+	filterTrue
+)
+
+func truthOf(b bool) filterTruth {
+	if b {
+		return filterTrue
+	}
+	return filterFalse
+}
+
+// Matches evaluates the expression against a user in Go, with the result the compiled SQL would have:
+// a comparison on a NULL field is unknown, unknown propagates like in SQL and doesn't match.
+// now stands in for CURRENT_TIMESTAMP. Timestamps are compared as the UTC wall clock times pgx scans them as,
+// which matches the database as long as its session time zone is UTC.
+func (e FilterExpr) Matches(user UserCohort, now time.Time) (bool, error) {
+	if err := e.Validate(); err != nil {
+		return false, err
+	}
+	result, err := e.eval(user, now.UTC())
+	return result == filterTrue, err
+}
+
+func (e FilterExpr) eval(user UserCohort, now time.Time) (filterTruth, error) {
+	switch {
+	case len(e.And) > 0:
+		result := filterTrue
+		for _, expr := range e.And {
+			t, err := expr.eval(user, now)
+			if err != nil {
+				return filterUnknown, err
+			}
+			if t == filterFalse {
+				return filterFalse, nil
+			}
+			if t == filterUnknown {
+				result = filterUnknown
+			}
+		}
+		return result, nil
+	case len(e.Or) > 0:
+		result := filterFalse
+		for _, expr := range e.Or {
+			t, err := expr.eval(user, now)
+			if err != nil {
+				return filterUnknown, err
+			}
+			if t == filterTrue {
+				return filterTrue, nil
+			}
+			if t == filterUnknown {
+				result = filterUnknown
+			}
+		}
+		return result, nil
+	case e.Not != nil:
+		t, err := e.Not.eval(user, now)
+		if err != nil || t == filterUnknown {
+			return filterUnknown, err
+		}
+		return truthOf(t == filterFalse), nil
+	case e.Pref != "":
+		// Like the COALESCE of the SQL, NotificationPreferences already fills in the defaults
+		value, _ := user.NotificationPrefs.Lookup(e.Pref)
+		if e.Op == FilterOpNeq {
+			return truthOf(value != e.Value.(bool)), nil
+		}
+		return truthOf(value == e.Value.(bool)), nil
+	case e.Cohort != "":
+		cohortType, err := ClassifyUser(user, now)
+		if err != nil {
+			return filterUnknown, err
+		}
+		return truthOf(cohortType == e.Cohort), nil
+	}
+	return e.evalField(user, now)
+}
+
+func (e FilterExpr) evalField(user UserCohort, now time.Time) (filterTruth, error) {
+	fieldType := filterFields[e.Field]
+	value, err := user.filterField(e.Field)
+	if err != nil {
+		return filterUnknown, err
+	}
+
+	switch e.Op {
+	case FilterOpIsNull:
+		return truthOf(value == nil), nil
+	case FilterOpNotNull:
+		return truthOf(value != nil), nil
+	}
+	if value == nil {
+		return filterUnknown, nil
+	}
+
+	switch e.Op {
+	case FilterOpEq, FilterOpNeq, FilterOpLt, FilterOpLte, FilterOpGt, FilterOpGte:
+		want, err := filterValue(e.Field, fieldType, e.Value)
+		if err != nil {
+			return filterUnknown, err
+		}
+		cmp := compareFilterValues(value, want)
+		switch e.Op {
+		case FilterOpEq:
+			return truthOf(cmp == 0), nil
+		case FilterOpNeq:
+			return truthOf(cmp != 0), nil
+		case FilterOpLt:
+			return truthOf(cmp < 0), nil
+		case FilterOpLte:
+			return truthOf(cmp <= 0), nil
+		case FilterOpGt:
+			return truthOf(cmp > 0), nil
+		default:
+			return truthOf(cmp >= 0), nil
+		}
+
+	case FilterOpIn, FilterOpNotIn:
+		found := false
+		for _, item := range e.Value.([]interface{}) {
+			want, err := filterValue(e.Field, fieldType, item)
+			if err != nil {
+				return filterUnknown, err
+			}
+			if compareFilterValues(value, want) == 0 {
+				found = true
+				break
+			}
+		}
+		return truthOf(found == (e.Op == FilterOpIn)), nil
+	}
+
+	// Date-relative ops, see the FilterOp constants for what each one compiles to
+	hours, err := parseFilterOffset(e.Value)
+	if err != nil {
+		return filterUnknown, err
+	}
+	at := value.(time.Time)
+	offset := time.Duration(hours) * time.Hour
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch e.Op {
+	case FilterOpWithin:
+		return truthOf(at.After(today) && !at.After(today.Add(offset))), nil
+	case FilterOpWithinLast:
+		return truthOf(!at.Before(now.Add(-offset)) && !at.After(now)), nil
+	case FilterOpOlderThan:
+		return truthOf(at.Before(now.Add(-offset))), nil
+	case FilterOpBefore:
+		return truthOf(at.Before(today.Add(offset))), nil
+	case FilterOpAfter:
+		return truthOf(at.After(today.Add(offset))), nil
+	}
+	return filterUnknown, fmt.Errorf("unknown filter op %q on %s", e.Op, e.Field)
+}
+
+// filterField returns the value of a users column as filterValue would convert it, nil for NULL
+func (u UserCohort) filterField(field string) (interface{}, error) {
+	timeOrNull := func(t *time.Time) interface{} {
+		if t == nil {
+			return nil
+		}
+		return *t
+	}
+
+	switch field {
+	case "id":
+		return int64(u.UserID), nil
+	case "email":
+		return u.Email, nil
+	case "username":
+		return u.Username, nil
+	case "first_name":
+		return u.FirstName, nil
+	case "last_name":
+		return u.LastName, nil
+	case "is_premium_user":
+		return u.IsPremiumUser, nil
+	case "subscription_tier":
+		return u.SubscriptionTier, nil
+	case "subscription_start_date":
+		return timeOrNull(u.SubscriptionStart), nil
+	case "subscription_end_date":
+		return timeOrNull(u.SubscriptionEnd), nil
+	case "timezone":
+		return u.Timezone, nil
+	case "locale":
+		return u.Locale, nil
+	case "created_at":
+		return u.CreatedAt, nil
+	case "last_login_at":
+		return timeOrNull(u.LastLoginAt), nil
+	}
+	return nil, fmt.Errorf("%s can only be filtered on in SQL", field)
+}
+
+// compareFilterValues compares two values of the same field type, booleans only compare as equal or not
+func compareFilterValues(a interface{}, b interface{}) int {
+	switch a := a.(type) {
+	case string:
+		return strings.Compare(a, b.(string))
+	case int64:
+		b := b.(int64)
+		if a < b {
+			return -1
+		}
+		if a > b {
+			return 1
+		}
+		return 0
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	case bool:
+		if a == b.(bool) {
+			return 0
+		}
+		return 1
+	}
+	return 1
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// ErrLifecycleRuleNameConflict is returned when another rule already has the name
+var ErrLifecycleRuleNameConflict = errors.New("a lifecycle rule with this name already exists")
+
+// ErrLifecycleRuleTemplateNotFound is returned when the template of a rule doesn't exist
+var ErrLifecycleRuleTemplateNotFound = errors.New("the template of the lifecycle rule does not exist")
+
+// LifecycleRule sends a template to the users of a cohort, and again to the same user only once the cooldown has passed
+type LifecycleRule struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Empty means all active users, narrowed down by Filters
+	CohortType    UserCohortType     `json:"cohort_type"`
+	Filters       *CohortFilters     `json:"filters"`
+	TemplateID    int                `json:"template_id"`
+	ChannelID     *int               `json:"channel_id"`
+	CooldownHours int                `json:"cooldown_hours"`
+	Enabled       bool               `json:"enabled"`
+	Stats         LifecycleRuleStats `json:"stats"`
+	CreatedAt     time.Time          `json:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+}
+
+type LifecycleRuleStats struct {
+	TotalRuns int        `json:"total_runs"`
+	TotalSent int        `json:"total_sent"`
+	LastRunAt *time.Time `json:"last_run_at"`
+	// Users in the cohort on the last run, and those of them outside the cooldown that were sent to
+	LastRunMatched int `json:"last_run_matched"`
+	LastRunSent    int `json:"last_run_sent"`
+	// Notification the deliveries of the last run are recorded under, nil if it sent nothing
+	LastRunNotificationID *int `json:"last_run_notification_id"`
+}
+
+func (r LifecycleRule) Cooldown() time.Duration {
+	return time.Duration(r.CooldownHours) * time.Hour
+}
+
+// LifecycleSend is a user a rule sent to
+type LifecycleSend struct {
+	ID             int       `json:"id"`
+	RuleID         int       `json:"rule_id"`
+	UserID         int       `json:"user_id"`
+	NotificationID int       `json:"notification_id"`
+	SentAt         time.Time `json:"sent_at"`
+}
+
+type LifecycleRuleRepo struct {
+	DB *pgxpool.Pool
+}
+
+type ILifecycleRuleRepository interface {
+	CreateRule(ctx context.Context, rule LifecycleRule) (int, error)
+	GetRuleByID(ctx context.Context, id int) (*LifecycleRule, error)
+	ListRules(ctx context.Context, onlyEnabled bool) ([]LifecycleRule, error)
+	// UpdateRule replaces the definition of a rule and keeps its stats, pgx.ErrNoRows if it doesn't exist
+	UpdateRule(ctx context.Context, rule LifecycleRule) error
+	DeleteRule(ctx context.Context, id int) (bool, error)
+	// EnqueueSends claims the users a run of the rule sends to, leaving out those it sent to within its cooldown,
+	// and saves the outbox message delivering to each of them in one transaction.
+	// The notification of the run is created along with the first users claimed, so a run with nobody to send to leaves
+	// nothing behind; pass the returned id back for the later batches of the same run. Returns the number of users claimed.
+	EnqueueSends(ctx context.Context, rule LifecycleRule, notificationID int, notification Notification, userIDs []int, message func(send LifecycleSend) (OutboxMessage, error)) (int, int, error)
+	// RecordRun adds a finished run to the stats of the rule
+	RecordRun(ctx context.Context, ruleID int, notificationID int, matched int, sent int) error
+}
+
+func NewLifecycleRuleRepo(db *pgxpool.Pool) ILifecycleRuleRepository {
+	return &LifecycleRuleRepo{
+		DB: db,
+	}
+}
+
+const lifecycleRuleColumns = `id, name, cohort_type, filters, template_id, channel_id, cooldown_hours, enabled,
+			  total_runs, total_sent, last_run_at, last_run_matched, last_run_sent, last_run_notification_id, created_at, updated_at`
+
+func scanLifecycleRule(row pgx.Row) (LifecycleRule, error) {
+	var rule LifecycleRule
+	err := row.Scan(
+		&rule.ID,
+		&rule.Name,
+		&rule.CohortType,
+		&rule.Filters,
+		&rule.TemplateID,
+		&rule.ChannelID,
+		&rule.CooldownHours,
+		&rule.Enabled,
+		&rule.Stats.TotalRuns,
+		&rule.Stats.TotalSent,
+		&rule.Stats.LastRunAt,
+		&rule.Stats.LastRunMatched,
+		&rule.Stats.LastRunSent,
+		&rule.Stats.LastRunNotificationID,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+	return rule, err
+}
+
+func (r *LifecycleRuleRepo) CreateRule(ctx context.Context, rule LifecycleRule) (int, error) {
+	var id int
+	query := `INSERT INTO lifecycle_rules (name, cohort_type, filters, template_id, channel_id, cooldown_hours, enabled) 
+			  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err := r.DB.QueryRow(ctx, query,
+		rule.Name,
+		rule.CohortType,
+		rule.Filters,
+		rule.TemplateID,
+		rule.ChannelID,
+		rule.CooldownHours,
+		rule.Enabled).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, ErrLifecycleRuleNameConflict
+	}
+	if isForeignKeyViolation(err) {
+		return 0, ErrLifecycleRuleTemplateNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create lifecycle rule: %w", err)
+	}
+	return id, nil
+}
+
+func (r *LifecycleRuleRepo) GetRuleByID(ctx context.Context, id int) (*LifecycleRule, error) {
+	query := `SELECT ` + lifecycleRuleColumns + ` FROM lifecycle_rules WHERE id = $1`
+
+	rule, err := scanLifecycleRule(r.DB.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *LifecycleRuleRepo) ListRules(ctx context.Context, onlyEnabled bool) ([]LifecycleRule, error) {
+	query := `SELECT ` + lifecycleRuleColumns + ` FROM lifecycle_rules WHERE enabled OR NOT $1 ORDER BY id`
+
+	rows, err := r.DB.Query(ctx, query, onlyEnabled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lifecycle rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []LifecycleRule
+	for rows.Next() {
+		rule, err := scanLifecycleRule(rows)
+		if err != nil {
+			fmt.Printf("Error scanning lifecycle rule: %v\n", err)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func (r *LifecycleRuleRepo) UpdateRule(ctx context.Context, rule LifecycleRule) error {
+	query := `UPDATE lifecycle_rules 
+			  SET name = $1, cohort_type = $2, filters = $3, template_id = $4, channel_id = $5, cooldown_hours = $6, enabled = $7, 
+			      updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $8`
+	tag, err := r.DB.Exec(ctx, query,
+		rule.Name,
+		rule.CohortType,
+		rule.Filters,
+		rule.TemplateID,
+		rule.ChannelID,
+		rule.CooldownHours,
+		rule.Enabled,
+		rule.ID)
+	if isUniqueViolation(err) {
+		return ErrLifecycleRuleNameConflict
+	}
+	if isForeignKeyViolation(err) {
+		return ErrLifecycleRuleTemplateNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update lifecycle rule: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *LifecycleRuleRepo) DeleteRule(ctx context.Context, id int) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `DELETE FROM lifecycle_rules WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete lifecycle rule: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// First key of the advisory locks EnqueueSends takes per rule, so they don't clash with other advisory locks
+const lifecycleSendsLockSpace = 1001
+
+func (r *LifecycleRuleRepo) EnqueueSends(ctx context.Context, rule LifecycleRule, notificationID int, notification Notification, userIDs []int, message func(send LifecycleSend) (OutboxMessage, error)) (int, int, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return notificationID, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// The cooldown check and the claim aren't atomic on their own, two processors running the same rule would both
+	// claim a user. The lock serializes the claims of a rule until commit, the claim after it sees the other's sends.
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, lifecycleSendsLockSpace, rule.ID); err != nil {
+		return notificationID, 0, fmt.Errorf("failed to lock lifecycle rule %d: %w", rule.ID, err)
+	}
+
+	query := `INSERT INTO lifecycle_sends (rule_id, user_id)
+			  SELECT $1, candidate.user_id FROM unnest($2::int[]) AS candidate(user_id)
+			  WHERE NOT EXISTS (
+			      SELECT 1 FROM lifecycle_sends s 
+			      WHERE s.rule_id = $1 AND s.user_id = candidate.user_id 
+			      AND s.sent_at > CURRENT_TIMESTAMP - make_interval(hours => $3)
+			  )
+			  RETURNING id, rule_id, user_id, sent_at`
+	rows, err := tx.Query(ctx, query, rule.ID, userIDs, rule.CooldownHours)
+	if err != nil {
+		return notificationID, 0, fmt.Errorf("failed to claim lifecycle sends: %w", err)
+	}
+	var sends []LifecycleSend
+	for rows.Next() {
+		var send LifecycleSend
+		if err := rows.Scan(&send.ID, &send.RuleID, &send.UserID, &send.SentAt); err != nil {
+			rows.Close()
+			return notificationID, 0, fmt.Errorf("failed to scan lifecycle send: %w", err)
+		}
+		sends = append(sends, send)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return notificationID, 0, rows.Err()
+	}
+	if len(sends) == 0 {
+		return notificationID, 0, nil
+	}
+
+	// Only kept once the transaction commits, a failed first batch leaves the run without a notification
+	runNotificationID := notificationID
+	if runNotificationID == 0 {
+		runNotificationID, err = insertNotification(ctx, tx, notification)
+		if err != nil {
+			return notificationID, 0, err
+		}
+	}
+
+	sendIDs := make([]int, len(sends))
+	for i, send := range sends {
+		sendIDs[i] = send.ID
+	}
+	_, err = tx.Exec(ctx, `UPDATE lifecycle_sends SET notification_id = $1 WHERE id = ANY($2)`, runNotificationID, sendIDs)
+	if err != nil {
+		return notificationID, 0, fmt.Errorf("failed to link lifecycle sends: %w", err)
+	}
+
+	for _, send := range sends {
+		send.NotificationID = runNotificationID
+		m, err := message(send)
+		if err != nil {
+			return notificationID, 0, fmt.Errorf("failed to build task of lifecycle send %d: %w", send.ID, err)
+		}
+		m.NotificationID = runNotificationID
+		if err := insertOutboxMessage(ctx, tx, m); err != nil {
+			return notificationID, 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return notificationID, 0, fmt.Errorf("failed to commit lifecycle sends: %w", err)
+	}
+	return runNotificationID, len(sends), nil
+}
+
+func (r *LifecycleRuleRepo) RecordRun(ctx context.Context, ruleID int, notificationID int, matched int, sent int) error {
+	var lastNotificationID *int
+	if notificationID != 0 {
+		lastNotificationID = &notificationID
+	}
+
+	query := `UPDATE lifecycle_rules 
+			  SET total_runs = total_runs + 1, total_sent = total_sent + $1, last_run_at = CURRENT_TIMESTAMP, 
+			      last_run_matched = $2, last_run_sent = $1, last_run_notification_id = $3
+			  WHERE id = $4`
+	_, err := r.DB.Exec(ctx, query, sent, matched, lastNotificationID, ruleID)
+	if err != nil {
+		return fmt.Errorf("failed to record lifecycle rule run: %w", err)
+	}
+	return nil
+}
@@ -2,43 +2,84 @@ package models
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"time"
 )
 
+// ErrIdempotencyKeyConflict is returned when a notification with the same idempotency key already exists
+var ErrIdempotencyKeyConflict = errors.New("a notification with this idempotency key already exists")
+
 type Notification struct {
 	ID          int    `json:"id"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	// This field must only be a marshal of the NotificationPayload struct
-	Payload       []byte             `json:"payload"`
+	Payload       json.RawMessage    `json:"payload"`
 	ChannelID     *int               `json:"channel_id"`
 	TransactionId string             `json:"transaction_id"`
 	Status        NotificationStatus `json:"status"`
 	CreatedAt     time.Time          `json:"created_at"`
+	// When a scheduled notification started processing, nil for ones processing since created_at
+	ProcessingStartedAt *time.Time `json:"processing_started_at,omitempty"`
+	// Client supplied Idempotency-Key, unique across notifications
+	IdempotencyKey *string `json:"idempotency_key,omitempty"`
 }
 
 type NotificationPayload struct {
-	Link string `json:"link"`
+	Link       string `json:"link"`
+	WebhookURL string `json:"webhook_url,omitempty"`
 }
 
+// Delivery channels a notification can be routed to, stored as notifications.channel_id.
+// A notification without a channel id goes out on the processor's default channel.
+const (
+	ChannelPush    = 1
+	ChannelEmail   = 2
+	ChannelSMS     = 3
+	ChannelWebhook = 4
+)
+
 type NotificationStatus string
 
 const (
 	NotificationStatusProcessing NotificationStatus = "PROCESSING"
 	NotificationStatusSuccess    NotificationStatus = "SUCCESS"
 	NotificationStatusFailed     NotificationStatus = "FAILED"
+	// Waiting for its send_at, can still be cancelled
+	NotificationStatusScheduled NotificationStatus = "SCHEDULED"
+	NotificationStatusCancelled NotificationStatus = "CANCELLED"
+	// Not sent because the recipient opted out of every channel it could go out on
+	NotificationStatusSkipped NotificationStatus = "SKIPPED"
 )
 
+// NotificationListFilter narrows down ListNotifications. Results are ordered by id, newest first,
+// and Cursor is the id of the last notification of the previous page.
+type NotificationListFilter struct {
+	Status *NotificationStatus
+	Since  *time.Time
+	Until  *time.Time
+	Cursor int
+	Limit  int
+}
+
 type NotificationRepo struct {
 	DB *pgxpool.Pool
 }
 
 type INotificationRepository interface {
 	CreateNotification(ctx context.Context, notification Notification) (int, error)
+	CreateNotificationWithOutbox(ctx context.Context, notification Notification, message OutboxMessage, payload func(notificationID int) ([]byte, error)) (int, error)
 	GetNotificationByID(ctx context.Context, id int) (*Notification, error)
+	GetNotificationByTransactionID(ctx context.Context, transactionID string) (*Notification, error)
+	GetNotificationByIdempotencyKey(ctx context.Context, key string) (*Notification, error)
+	ListNotifications(ctx context.Context, filter NotificationListFilter) ([]Notification, error)
 	MarkNotificationAsFailed(ctx context.Context, task_id string) error
+	// UpdateNotificationStatus leaves cancelled and failed notifications alone, moving to PROCESSING records when it started
+	UpdateNotificationStatus(ctx context.Context, id int, status NotificationStatus) error
 	GetAllNotifications(ctx context.Context) ([]Notification, error)
 	GetPendingNotifications(ctx context.Context) ([]Notification, error)
 }
@@ -51,13 +92,14 @@ func NewNotificationRepo(db *pgxpool.Pool) INotificationRepository {
 
 func (r *NotificationRepo) CreateNotification(ctx context.Context, notification Notification) (int, error) {
 	var id int
-	query := `INSERT INTO notifications (title, description, payload, transaction_id, status) 
-			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	query := `INSERT INTO notifications (title, description, payload, channel_id, transaction_id, status) 
+			  VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
 	err := r.DB.QueryRow(ctx,
 		query,
 		notification.Title,
 		notification.Description,
 		notification.Payload,
+		notification.ChannelID,
 		notification.TransactionId,
 		notification.Status).Scan(&id)
 	if err != nil {
@@ -67,8 +109,69 @@ func (r *NotificationRepo) CreateNotification(ctx context.Context, notification
 	return id, nil
 }
 
+// CreateNotificationWithOutbox saves the notification and the outbox message of the task that delivers it in one transaction.
+// The task payload is built once the notification id is known, so it can refer back to the notification.
+func (r *NotificationRepo) CreateNotificationWithOutbox(ctx context.Context, notification Notification, message OutboxMessage, payload func(notificationID int) ([]byte, error)) (int, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	id, err := insertNotificationWithOutbox(ctx, tx, notification, message, payload)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit notification: %w", err)
+	}
+	return id, nil
+}
+
+// insertNotificationWithOutbox is CreateNotificationWithOutbox within a transaction of the caller
+func insertNotificationWithOutbox(ctx context.Context, tx pgx.Tx, notification Notification, message OutboxMessage, payload func(notificationID int) ([]byte, error)) (int, error) {
+	id, err := insertNotification(ctx, tx, notification)
+	if err != nil {
+		return 0, err
+	}
+
+	message.NotificationID = id
+	message.Payload, err = payload(id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build task payload: %w", err)
+	}
+	if err := insertOutboxMessage(ctx, tx, message); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func insertNotification(ctx context.Context, tx pgx.Tx, notification Notification) (int, error) {
+	var id int
+	query := `INSERT INTO notifications (title, description, payload, channel_id, transaction_id, status, idempotency_key) 
+			  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	err := tx.QueryRow(ctx,
+		query,
+		notification.Title,
+		notification.Description,
+		notification.Payload,
+		notification.ChannelID,
+		notification.TransactionId,
+		notification.Status,
+		notification.IdempotencyKey).Scan(&id)
+	if isUniqueViolation(err) && notification.IdempotencyKey != nil {
+		return 0, ErrIdempotencyKeyConflict
+	}
+	if err != nil {
+		fmt.Println("error saving notification", err)
+		return 0, err
+	}
+	return id, nil
+}
+
 func (r *NotificationRepo) GetNotificationByID(ctx context.Context, id int) (*Notification, error) {
-	query := `SELECT id, title, description, user_id, channel_id, transaction_id 
+	query := `SELECT id, title, description, payload, channel_id, transaction_id, status, created_at 
 			  FROM notifications WHERE id = $1`
 	row := r.DB.QueryRow(ctx, query, id)
 
@@ -77,14 +180,130 @@ func (r *NotificationRepo) GetNotificationByID(ctx context.Context, id int) (*No
 		&notification.ID,
 		&notification.Title,
 		&notification.Description,
+		&notification.Payload,
+		&notification.ChannelID,
+		&notification.TransactionId,
+		&notification.Status,
+		&notification.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+func (r *NotificationRepo) GetNotificationByTransactionID(ctx context.Context, transactionID string) (*Notification, error) {
+	query := `SELECT id, title, description, payload, channel_id, transaction_id, status, created_at 
+			  FROM notifications WHERE transaction_id = $1`
+	row := r.DB.QueryRow(ctx, query, transactionID)
+
+	var notification Notification
+	err := row.Scan(
+		&notification.ID,
+		&notification.Title,
+		&notification.Description,
+		&notification.Payload,
+		&notification.ChannelID,
+		&notification.TransactionId,
+		&notification.Status,
+		&notification.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &notification, nil
+}
+
+func (r *NotificationRepo) GetNotificationByIdempotencyKey(ctx context.Context, key string) (*Notification, error) {
+	query := `SELECT id, title, description, payload, channel_id, transaction_id, status, created_at, idempotency_key 
+			  FROM notifications WHERE idempotency_key = $1`
+	row := r.DB.QueryRow(ctx, query, key)
+
+	var notification Notification
+	err := row.Scan(
+		&notification.ID,
+		&notification.Title,
+		&notification.Description,
+		&notification.Payload,
 		&notification.ChannelID,
-		&notification.TransactionId)
+		&notification.TransactionId,
+		&notification.Status,
+		&notification.CreatedAt,
+		&notification.IdempotencyKey)
 	if err != nil {
 		return nil, err
 	}
 	return &notification, nil
 }
 
+func (r *NotificationRepo) ListNotifications(ctx context.Context, filter NotificationListFilter) ([]Notification, error) {
+	query := `SELECT id, title, description, payload, channel_id, transaction_id, status, created_at 
+			  FROM notifications WHERE true`
+
+	var args []interface{}
+	argIndex := 1
+
+	if filter.Status != nil {
+		query += fmt.Sprintf(" AND status = $%d", argIndex)
+		args = append(args, *filter.Status)
+		argIndex++
+	}
+
+	if filter.Since != nil {
+		query += fmt.Sprintf(" AND created_at >= $%d", argIndex)
+		args = append(args, *filter.Since)
+		argIndex++
+	}
+
+	if filter.Until != nil {
+		query += fmt.Sprintf(" AND created_at < $%d", argIndex)
+		args = append(args, *filter.Until)
+		argIndex++
+	}
+
+	if filter.Cursor > 0 {
+		query += fmt.Sprintf(" AND id < $%d", argIndex)
+		args = append(args, filter.Cursor)
+		argIndex++
+	}
+
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", argIndex)
+	args = append(args, filter.Limit)
+
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		fmt.Println("Error listing notifications:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+
+	for rows.Next() {
+		var n Notification
+		err := rows.Scan(
+			&n.ID,
+			&n.Title,
+			&n.Description,
+			&n.Payload,
+			&n.ChannelID,
+			&n.TransactionId,
+			&n.Status,
+			&n.CreatedAt,
+			&n.ProcessingStartedAt,
+		)
+		if err != nil {
+			fmt.Println("Error scanning notification row:", err)
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	return notifications, nil
+}
+
 func (r *NotificationRepo) GetAllNotifications(ctx context.Context) ([]Notification, error) {
 	query := `SELECT id, title, description, payload, channel_id, transaction_id, status, created_at FROM notifications`
 
@@ -108,6 +327,7 @@ func (r *NotificationRepo) GetAllNotifications(ctx context.Context) ([]Notificat
 			&n.TransactionId,
 			&n.Status,
 			&n.CreatedAt,
+			&n.ProcessingStartedAt,
 		)
 		if err != nil {
 			fmt.Println("Error scanning notification row:", err)
@@ -124,7 +344,8 @@ func (r *NotificationRepo) GetAllNotifications(ctx context.Context) ([]Notificat
 }
 
 func (r *NotificationRepo) GetPendingNotifications(ctx context.Context) ([]Notification, error) {
-	query := `SELECT id, title, description, payload, channel_id, transaction_id, status, created_at 
+	query := `SELECT id, title, description, payload, channel_id, transaction_id, status, created_at,
+			  COALESCE(processing_started_at, created_at)
 			  FROM notifications WHERE status = $1`
 	rows, err := r.DB.Query(ctx, query, NotificationStatusProcessing)
 	if err != nil {
@@ -146,6 +367,7 @@ func (r *NotificationRepo) GetPendingNotifications(ctx context.Context) ([]Notif
 			&n.TransactionId,
 			&n.Status,
 			&n.CreatedAt,
+			&n.ProcessingStartedAt,
 		)
 		if err != nil {
 			fmt.Println("Error scanning notification row:", err)
@@ -162,11 +384,28 @@ func (r *NotificationRepo) GetPendingNotifications(ctx context.Context) ([]Notif
 }
 
 func (r *NotificationRepo) MarkNotificationAsFailed(ctx context.Context, task_id string) error {
-	query := `UPDATE notifications SET status = $1 WHERE transaction_id = $2`
-	_, err := r.DB.Exec(ctx, query, NotificationStatusFailed, task_id)
+	// Only while it is still processing, it may have finished since it was read
+	query := `UPDATE notifications SET status = $1 WHERE transaction_id = $2 AND status = $3`
+	_, err := r.DB.Exec(ctx, query, NotificationStatusFailed, task_id, NotificationStatusProcessing)
 	if err != nil {
 		fmt.Println("Error updating notification status:", err)
 		return err
 	}
 	return nil
 }
+
+func (r *NotificationRepo) UpdateNotificationStatus(ctx context.Context, id int, status NotificationStatus) error {
+	// A cancelled or failed notification keeps its status, a late chunk finishing doesn't turn it into a success
+	query := `UPDATE notifications SET status = $1,
+			  processing_started_at = CASE WHEN $1 = $3 AND status <> $3 THEN CURRENT_TIMESTAMP ELSE processing_started_at END
+			  WHERE id = $2 AND status NOT IN ($4, $5)`
+	tag, err := r.DB.Exec(ctx, query, status, id, NotificationStatusProcessing, NotificationStatusCancelled, NotificationStatusFailed)
+	if err != nil {
+		fmt.Println("Error updating notification status:", err)
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		fmt.Printf("Notification %d was not set to %s, it is missing, cancelled or failed\n", id, status)
+	}
+	return nil
+}
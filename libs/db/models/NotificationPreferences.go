@@ -0,0 +1,46 @@
+package models
+
+import "encoding/json"
+
+// NotificationPreferences is the users.notification_preferences JSONB column
+type NotificationPreferences struct {
+	Email bool `json:"email"`
+	Push  bool `json:"push"`
+	SMS   bool `json:"sms"`
+}
+
+// DefaultNotificationPreferences matches the column default of the users table
+func DefaultNotificationPreferences() NotificationPreferences {
+	return NotificationPreferences{Email: true, Push: true, SMS: false}
+}
+
+// UnmarshalJSON starts from the defaults, so a key missing from the stored JSON keeps its default
+func (p *NotificationPreferences) UnmarshalJSON(data []byte) error {
+	type plain NotificationPreferences
+	prefs := plain(DefaultNotificationPreferences())
+	if err := json.Unmarshal(data, &prefs); err != nil {
+		return err
+	}
+	*p = NotificationPreferences(prefs)
+	return nil
+}
+
+// Allows reports whether the user accepts notifications on the named channel.
+// Channels the preferences don't cover (webhooks, the default channel) are always allowed.
+func (p NotificationPreferences) Allows(channel string) bool {
+	allowed, covered := p.Lookup(channel)
+	return allowed || !covered
+}
+
+// Lookup returns the preference stored under a JSON key, and whether there is such a key
+func (p NotificationPreferences) Lookup(key string) (bool, bool) {
+	switch key {
+	case "email":
+		return p.Email, true
+	case "push":
+		return p.Push, true
+	case "sms":
+		return p.SMS, true
+	}
+	return false, false
+}
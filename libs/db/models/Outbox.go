@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// OutboxMessage is an asynq task waiting to be relayed, written in the same transaction as its notification
+type OutboxMessage struct {
+	ID             int           `json:"id"`
+	NotificationID int           `json:"notification_id"`
+	TaskID         string        `json:"task_id"`
+	TaskType       string        `json:"task_type"`
+	Payload        []byte        `json:"payload"`
+	Queue          string        `json:"queue"`
+	MaxRetry       int           `json:"max_retry"`
+	Timeout        time.Duration `json:"timeout"`
+	// Passed to asynq.Unique when set
+	UniqueTTL time.Duration `json:"unique_ttl"`
+	// Passed to asynq.ProcessAt and asynq.Deadline when set. Stored as TIMESTAMPTZ, a TIMESTAMP column would drop the offset.
+	ProcessAt *time.Time          `json:"process_at"`
+	Deadline  *time.Time          `json:"deadline"`
+	Status    OutboxMessageStatus `json:"status"`
+	Attempts  int                 `json:"attempts"`
+	LastError *string             `json:"last_error"`
+	CreatedAt time.Time           `json:"created_at"`
+	SentAt    *time.Time          `json:"sent_at"`
+}
+
+type OutboxMessageStatus string
+
+const (
+	OutboxMessageStatusPending   OutboxMessageStatus = "PENDING"
+	OutboxMessageStatusSent      OutboxMessageStatus = "SENT"
+	OutboxMessageStatusCancelled OutboxMessageStatus = "CANCELLED"
+)
+
+type OutboxRepo struct {
+	DB *pgxpool.Pool
+}
+
+type IOutboxRepository interface {
+	// RelayPending locks up to limit pending messages, oldest first, and hands them to relay one by one.
+	// Messages relay succeeds for are marked sent, failures are recorded and retried on the next call.
+	// Locked rows are skipped, so several processors can relay concurrently. Returns the number of messages handled.
+	RelayPending(ctx context.Context, limit int, relay func(message OutboxMessage) error) (int, error)
+	GetMessageByNotificationID(ctx context.Context, notificationID int) (*OutboxMessage, error)
+	// CancelPending cancels the message of a notification if it hasn't been relayed yet, and reports whether it did
+	CancelPending(ctx context.Context, notificationID int) (bool, error)
+}
+
+const outboxColumns = `id, notification_id, task_id, task_type, payload, queue, max_retry, timeout_seconds, unique_ttl_seconds, process_at, deadline, status, attempts, last_error, created_at, sent_at`
+
+func scanOutboxMessage(row pgx.Row) (OutboxMessage, error) {
+	var m OutboxMessage
+	var timeoutSeconds, uniqueTTLSeconds int
+	err := row.Scan(
+		&m.ID,
+		&m.NotificationID,
+		&m.TaskID,
+		&m.TaskType,
+		&m.Payload,
+		&m.Queue,
+		&m.MaxRetry,
+		&timeoutSeconds,
+		&uniqueTTLSeconds,
+		&m.ProcessAt,
+		&m.Deadline,
+		&m.Status,
+		&m.Attempts,
+		&m.LastError,
+		&m.CreatedAt,
+		&m.SentAt,
+	)
+	m.Timeout = time.Duration(timeoutSeconds) * time.Second
+	m.UniqueTTL = time.Duration(uniqueTTLSeconds) * time.Second
+	return m, err
+}
+
+func NewOutboxRepo(db *pgxpool.Pool) IOutboxRepository {
+	return &OutboxRepo{
+		DB: db,
+	}
+}
+
+func insertOutboxMessage(ctx context.Context, tx pgx.Tx, message OutboxMessage) error {
+	query := `INSERT INTO notification_outbox (notification_id, task_id, task_type, payload, queue, max_retry, timeout_seconds, unique_ttl_seconds, process_at, deadline, status)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err := tx.Exec(ctx,
+		query,
+		message.NotificationID,
+		message.TaskID,
+		message.TaskType,
+		message.Payload,
+		message.Queue,
+		message.MaxRetry,
+		int(message.Timeout.Seconds()),
+		int(message.UniqueTTL.Seconds()),
+		message.ProcessAt,
+		message.Deadline,
+		OutboxMessageStatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to write outbox message: %w", err)
+	}
+	return nil
+}
+
+func (r *OutboxRepo) RelayPending(ctx context.Context, limit int, relay func(message OutboxMessage) error) (int, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin outbox relay: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `SELECT ` + outboxColumns + `
+			  FROM notification_outbox WHERE status = $1
+			  ORDER BY id LIMIT $2
+			  FOR UPDATE SKIP LOCKED`
+	rows, err := tx.Query(ctx, query, OutboxMessageStatusPending, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch outbox messages: %w", err)
+	}
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		m, err := scanOutboxMessage(rows)
+		if err != nil {
+			fmt.Printf("Error scanning outbox message: %v\n", err)
+			continue
+		}
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, rows.Err()
+	}
+
+	for _, m := range messages {
+		if relayErr := relay(m); relayErr != nil {
+			_, err = tx.Exec(ctx,
+				`UPDATE notification_outbox SET attempts = attempts + 1, last_error = $1 WHERE id = $2`,
+				relayErr.Error(), m.ID)
+		} else {
+			_, err = tx.Exec(ctx,
+				`UPDATE notification_outbox SET attempts = attempts + 1, last_error = NULL, status = $1, sent_at = CURRENT_TIMESTAMP WHERE id = $2`,
+				OutboxMessageStatusSent, m.ID)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to update outbox message %d: %w", m.ID, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit outbox relay: %w", err)
+	}
+	return len(messages), nil
+}
+
+func (r *OutboxRepo) GetMessageByNotificationID(ctx context.Context, notificationID int) (*OutboxMessage, error) {
+	query := `SELECT ` + outboxColumns + ` FROM notification_outbox WHERE notification_id = $1`
+
+	m, err := scanOutboxMessage(r.DB.QueryRow(ctx, query, notificationID))
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *OutboxRepo) CancelPending(ctx context.Context, notificationID int) (bool, error) {
+	// Waits for a relay holding the row, after which the message is either still pending or already sent
+	query := `UPDATE notification_outbox SET status = $1 WHERE notification_id = $2 AND status = $3`
+	tag, err := r.DB.Exec(ctx, query, OutboxMessageStatusCancelled, notificationID, OutboxMessageStatusPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel outbox message: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
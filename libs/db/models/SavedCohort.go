@@ -0,0 +1,200 @@
+package models
+
+import (
+	"PingMeMaybe/libs/messagePatterns"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+	"time"
+)
+
+// ErrSavedCohortNameConflict is returned when another saved cohort already has the name
+var ErrSavedCohortNameConflict = errors.New("a cohort with this name already exists")
+
+// ErrSavedCohortInUse is returned when deleting a saved cohort that lifecycle rules or broadcasts yet to start refer to
+var ErrSavedCohortInUse = errors.New("the cohort is still in use")
+
+// SavedCohort is a named audience, a built-in cohort type narrowed down by filters.
+// Use it anywhere cohort filters are taken by setting filters.saved_cohort_id.
+type SavedCohort struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Empty means all active users, narrowed down by Filters
+	CohortType UserCohortType `json:"cohort_type"`
+	Filters    *CohortFilters `json:"filters"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// Exprs returns the definition of the cohort as expressions that all have to match
+func (c SavedCohort) Exprs() ([]FilterExpr, error) {
+	if c.Filters != nil && c.Filters.SavedCohortID != nil {
+		return nil, errors.New("a saved cohort can't refer to another saved cohort")
+	}
+	exprs, err := c.Filters.Exprs()
+	if err != nil {
+		return nil, err
+	}
+	if c.CohortType != "" {
+		if !c.CohortType.IsValid() {
+			return nil, fmt.Errorf("unknown cohort type: %s", c.CohortType)
+		}
+		exprs = append(exprs, CohortFilter(c.CohortType))
+	}
+	return exprs, nil
+}
+
+type SavedCohortRepo struct {
+	DB *pgxpool.Pool
+}
+
+type ISavedCohortRepository interface {
+	CreateCohort(ctx context.Context, cohort SavedCohort) (int, error)
+	GetCohortByID(ctx context.Context, id int) (*SavedCohort, error)
+	ListCohorts(ctx context.Context) ([]SavedCohort, error)
+	// UpdateCohort replaces the definition of a cohort, pgx.ErrNoRows if it doesn't exist
+	UpdateCohort(ctx context.Context, cohort SavedCohort) error
+	// DeleteCohort returns ErrSavedCohortInUse, naming its users, while something still needs the cohort
+	DeleteCohort(ctx context.Context, id int) (bool, error)
+}
+
+func NewSavedCohortRepo(db *pgxpool.Pool) ISavedCohortRepository {
+	return &SavedCohortRepo{
+		DB: db,
+	}
+}
+
+const savedCohortColumns = `id, name, description, cohort_type, filters, created_at, updated_at`
+
+func scanSavedCohort(row pgx.Row) (SavedCohort, error) {
+	var cohort SavedCohort
+	err := row.Scan(
+		&cohort.ID,
+		&cohort.Name,
+		&cohort.Description,
+		&cohort.CohortType,
+		&cohort.Filters,
+		&cohort.CreatedAt,
+		&cohort.UpdatedAt,
+	)
+	return cohort, err
+}
+
+// getSavedCohort is shared with UserCohortRepo, which resolves filters.saved_cohort_id
+func getSavedCohort(ctx context.Context, db *pgxpool.Pool, id int) (*SavedCohort, error) {
+	query := `SELECT ` + savedCohortColumns + ` FROM cohorts WHERE id = $1`
+
+	cohort, err := scanSavedCohort(db.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+	return &cohort, nil
+}
+
+func (r *SavedCohortRepo) CreateCohort(ctx context.Context, cohort SavedCohort) (int, error) {
+	var id int
+	query := `INSERT INTO cohorts (name, description, cohort_type, filters) VALUES ($1, $2, $3, $4) RETURNING id`
+	err := r.DB.QueryRow(ctx, query, cohort.Name, cohort.Description, cohort.CohortType, cohort.Filters).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, ErrSavedCohortNameConflict
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create cohort: %w", err)
+	}
+	return id, nil
+}
+
+func (r *SavedCohortRepo) GetCohortByID(ctx context.Context, id int) (*SavedCohort, error) {
+	return getSavedCohort(ctx, r.DB, id)
+}
+
+func (r *SavedCohortRepo) ListCohorts(ctx context.Context) ([]SavedCohort, error) {
+	query := `SELECT ` + savedCohortColumns + ` FROM cohorts ORDER BY id`
+
+	rows, err := r.DB.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cohorts: %w", err)
+	}
+	defer rows.Close()
+
+	var cohorts []SavedCohort
+	for rows.Next() {
+		cohort, err := scanSavedCohort(rows)
+		if err != nil {
+			fmt.Printf("Error scanning cohort: %v\n", err)
+			continue
+		}
+		cohorts = append(cohorts, cohort)
+	}
+
+	return cohorts, rows.Err()
+}
+
+func (r *SavedCohortRepo) UpdateCohort(ctx context.Context, cohort SavedCohort) error {
+	query := `UPDATE cohorts
+			  SET name = $1, description = $2, cohort_type = $3, filters = $4, updated_at = CURRENT_TIMESTAMP
+			  WHERE id = $5`
+	tag, err := r.DB.Exec(ctx, query, cohort.Name, cohort.Description, cohort.CohortType, cohort.Filters, cohort.ID)
+	if isUniqueViolation(err) {
+		return ErrSavedCohortNameConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update cohort: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+func (r *SavedCohortRepo) DeleteCohort(ctx context.Context, id int) (bool, error) {
+	users, err := r.cohortUsers(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if len(users) > 0 {
+		return false, fmt.Errorf("%w by %s", ErrSavedCohortInUse, strings.Join(users, ", "))
+	}
+
+	tag, err := r.DB.Exec(ctx, `DELETE FROM cohorts WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete cohort: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// cohortUsers names the lifecycle rules and the broadcasts that would fail to read their audience without the cohort:
+// scheduled ones, and those processing that haven't finished their snapshot. Both refer to it with
+// filters.saved_cohort_id, broadcasts in their exclusions as well.
+func (r *SavedCohortRepo) cohortUsers(ctx context.Context, id int) ([]string, error) {
+	query := `SELECT 'lifecycle rule ' || name FROM lifecycle_rules
+			  WHERE filters @> jsonb_build_object('saved_cohort_id', $1::int)
+			  UNION ALL
+			  SELECT 'broadcast ' || n.id FROM notifications n
+			  JOIN notification_outbox o ON o.notification_id = n.id AND o.task_type = $2
+			  LEFT JOIN broadcast_snapshots s ON s.notification_id = n.id
+			  WHERE (n.status = $3 OR (n.status = $4 AND s.completed_at IS NULL))
+			  AND (convert_from(o.payload, 'UTF8')::jsonb -> 'filters' @> jsonb_build_object('saved_cohort_id', $1::int)
+			       OR convert_from(o.payload, 'UTF8')::jsonb -> 'exclusions' -> 'cohorts'
+			          @> jsonb_build_array(jsonb_build_object('filters', jsonb_build_object('saved_cohort_id', $1::int))))`
+
+	rows, err := r.DB.Query(ctx, query, id, messagePatterns.InitiateBulkBroadcast, NotificationStatusScheduled, NotificationStatusProcessing)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the users of cohort %d: %w", id, err)
+	}
+	defer rows.Close()
+
+	var users []string
+	for rows.Next() {
+		var user string
+		if err := rows.Scan(&user); err != nil {
+			return nil, fmt.Errorf("failed to scan cohort user: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+// ErrSuppressionUnknownUser is returned when a user to suppress doesn't exist
+var ErrSuppressionUnknownUser = errors.New("one of the users does not exist")
+
+// Suppression puts a user on the global suppression list, bulk broadcasts leave them out
+type Suppression struct {
+	UserID    int       `json:"user_id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type SuppressionRepo struct {
+	DB *pgxpool.Pool
+}
+
+type ISuppressionRepository interface {
+	// AddSuppressions suppresses the users and returns how many weren't already, the reason of those that were is kept
+	AddSuppressions(ctx context.Context, userIDs []int, reason string) (int, error)
+	RemoveSuppression(ctx context.Context, userID int) (bool, error)
+	// ListSuppressions pages through the suppression list by user id
+	ListSuppressions(ctx context.Context, afterUserID int, limit int) ([]Suppression, error)
+	// GetSuppressedUserIDs returns which of the users are suppressed
+	GetSuppressedUserIDs(ctx context.Context, userIDs []int) ([]int, error)
+}
+
+func NewSuppressionRepo(db *pgxpool.Pool) ISuppressionRepository {
+	return &SuppressionRepo{
+		DB: db,
+	}
+}
+
+func (r *SuppressionRepo) AddSuppressions(ctx context.Context, userIDs []int, reason string) (int, error) {
+	query := `INSERT INTO suppressions (user_id, reason)
+			  SELECT DISTINCT unnest($1::int[]), $2
+			  ON CONFLICT (user_id) DO NOTHING`
+	tag, err := r.DB.Exec(ctx, query, userIDs, reason)
+	if isForeignKeyViolation(err) {
+		return 0, ErrSuppressionUnknownUser
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to add suppressions: %w", err)
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+func (r *SuppressionRepo) RemoveSuppression(ctx context.Context, userID int) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `DELETE FROM suppressions WHERE user_id = $1`, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove suppression: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *SuppressionRepo) ListSuppressions(ctx context.Context, afterUserID int, limit int) ([]Suppression, error) {
+	query := `SELECT user_id, reason, created_at FROM suppressions
+			  WHERE user_id > $1
+			  ORDER BY user_id ASC LIMIT $2`
+
+	rows, err := r.DB.Query(ctx, query, afterUserID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list suppressions: %w", err)
+	}
+	defer rows.Close()
+
+	var suppressions []Suppression
+	for rows.Next() {
+		var suppression Suppression
+		if err := rows.Scan(&suppression.UserID, &suppression.Reason, &suppression.CreatedAt); err != nil {
+			fmt.Printf("Error scanning suppression: %v\n", err)
+			continue
+		}
+		suppressions = append(suppressions, suppression)
+	}
+
+	return suppressions, rows.Err()
+}
+
+func (r *SuppressionRepo) GetSuppressedUserIDs(ctx context.Context, userIDs []int) ([]int, error) {
+	rows, err := r.DB.Query(ctx, `SELECT user_id FROM suppressions WHERE user_id = ANY($1)`, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query suppressed users: %w", err)
+	}
+	defer rows.Close()
+
+	return scanUserIDs(rows)
+}
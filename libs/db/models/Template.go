@@ -0,0 +1,298 @@
+package models
+
+import (
+	"PingMeMaybe/libs/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"strings"
+	"time"
+)
+
+// ErrTemplateNameConflict is returned when another template already has the name
+var ErrTemplateNameConflict = errors.New("a template with this name already exists")
+
+// ErrTemplateInUse is returned when deleting a template that lifecycle rules still send
+var ErrTemplateInUse = errors.New("the template is used by lifecycle rules")
+
+// Template is reusable notification content, localized through its variants
+type Template struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Variants  []TemplateVariant `json:"variants"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// TemplateVariant is the content of a template in one locale. Title, Description and Link can hold
+// {{variable}} placeholders, see UserCohort.TemplateVariables for the variables a recipient provides.
+type TemplateVariant struct {
+	TemplateID  int       `json:"template_id"`
+	Locale      string    `json:"locale"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Link        string    `json:"link"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Variant returns the best variant for a locale, following utils.LocaleFallbacks down to the default locale.
+// It returns nil when none of them has a variant.
+func (t Template) Variant(locale string, defaultLocale string) *TemplateVariant {
+	for _, candidate := range utils.LocaleFallbacks(locale, defaultLocale) {
+		for i := range t.Variants {
+			if t.Variants[i].Locale == candidate {
+				return &t.Variants[i]
+			}
+		}
+	}
+	return nil
+}
+
+type TemplateRepo struct {
+	DB *pgxpool.Pool
+}
+
+type ITemplateRepository interface {
+	// CreateTemplate saves a template together with its variants
+	CreateTemplate(ctx context.Context, template Template) (int, error)
+	GetTemplateByID(ctx context.Context, id int) (*Template, error)
+	ListTemplates(ctx context.Context) ([]Template, error)
+	// UpdateTemplate renames a template and replaces all of its variants, pgx.ErrNoRows if it doesn't exist
+	UpdateTemplate(ctx context.Context, template Template) error
+	// DeleteTemplate reports whether there was a template to delete, its variants go with it
+	DeleteTemplate(ctx context.Context, id int) (bool, error)
+	// UpsertVariant adds or replaces the variant of one locale, pgx.ErrNoRows if the template doesn't exist
+	UpsertVariant(ctx context.Context, variant TemplateVariant) error
+	// DeleteVariant reports whether the template had a variant for the locale
+	DeleteVariant(ctx context.Context, templateID int, locale string) (bool, error)
+}
+
+func NewTemplateRepo(db *pgxpool.Pool) ITemplateRepository {
+	return &TemplateRepo{
+		DB: db,
+	}
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+func isForeignKeyViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23503"
+}
+
+func insertTemplateVariants(ctx context.Context, tx pgx.Tx, templateID int, variants []TemplateVariant) error {
+	query := `INSERT INTO template_variants (template_id, locale, title, description, link) 
+			  VALUES ($1, $2, $3, $4, $5)`
+
+	batch := &pgx.Batch{}
+	for _, v := range variants {
+		batch.Queue(query, templateID, v.Locale, v.Title, v.Description, v.Link)
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	defer results.Close()
+	for range variants {
+		if _, err := results.Exec(); err != nil {
+			return fmt.Errorf("failed to save template variant: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *TemplateRepo) CreateTemplate(ctx context.Context, template Template) (int, error) {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx, `INSERT INTO templates (name) VALUES ($1) RETURNING id`, template.Name).Scan(&id)
+	if isUniqueViolation(err) {
+		return 0, ErrTemplateNameConflict
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create template: %w", err)
+	}
+
+	if err := insertTemplateVariants(ctx, tx, id, template.Variants); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit template: %w", err)
+	}
+	return id, nil
+}
+
+// getVariants returns the variants of the given templates, keyed by template id
+func (r *TemplateRepo) getVariants(ctx context.Context, templateIDs []int) (map[int][]TemplateVariant, error) {
+	query := `SELECT template_id, locale, title, description, link, updated_at 
+			  FROM template_variants WHERE template_id = ANY($1) ORDER BY template_id, locale`
+
+	rows, err := r.DB.Query(ctx, query, templateIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch template variants: %w", err)
+	}
+	defer rows.Close()
+
+	variants := map[int][]TemplateVariant{}
+	for rows.Next() {
+		var v TemplateVariant
+		err := rows.Scan(&v.TemplateID, &v.Locale, &v.Title, &v.Description, &v.Link, &v.UpdatedAt)
+		if err != nil {
+			fmt.Printf("Error scanning template variant: %v\n", err)
+			continue
+		}
+		variants[v.TemplateID] = append(variants[v.TemplateID], v)
+	}
+
+	return variants, rows.Err()
+}
+
+func (r *TemplateRepo) GetTemplateByID(ctx context.Context, id int) (*Template, error) {
+	query := `SELECT id, name, created_at, updated_at FROM templates WHERE id = $1`
+
+	var t Template
+	err := r.DB.QueryRow(ctx, query, id).Scan(&t.ID, &t.Name, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	variants, err := r.getVariants(ctx, []int{id})
+	if err != nil {
+		return nil, err
+	}
+	t.Variants = variants[id]
+	return &t, nil
+}
+
+func (r *TemplateRepo) ListTemplates(ctx context.Context) ([]Template, error) {
+	query := `SELECT id, name, created_at, updated_at FROM templates ORDER BY id`
+
+	rows, err := r.DB.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+	defer rows.Close()
+
+	var templates []Template
+	var ids []int
+	for rows.Next() {
+		var t Template
+		err := rows.Scan(&t.ID, &t.Name, &t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			fmt.Printf("Error scanning template: %v\n", err)
+			continue
+		}
+		templates = append(templates, t)
+		ids = append(ids, t.ID)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	variants, err := r.getVariants(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range templates {
+		templates[i].Variants = variants[templates[i].ID]
+	}
+	return templates, nil
+}
+
+func (r *TemplateRepo) UpdateTemplate(ctx context.Context, template Template) error {
+	tx, err := r.DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `UPDATE templates SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+	tag, err := tx.Exec(ctx, query, template.Name, template.ID)
+	if isUniqueViolation(err) {
+		return ErrTemplateNameConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update template: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM template_variants WHERE template_id = $1`, template.ID); err != nil {
+		return fmt.Errorf("failed to replace template variants: %w", err)
+	}
+	if err := insertTemplateVariants(ctx, tx, template.ID, template.Variants); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit template: %w", err)
+	}
+	return nil
+}
+
+func (r *TemplateRepo) DeleteTemplate(ctx context.Context, id int) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `DELETE FROM templates WHERE id = $1`, id)
+	if isForeignKeyViolation(err) {
+		rules, err := r.lifecycleRulesUsing(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("%w: %s", ErrTemplateInUse, strings.Join(rules, ", "))
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to delete template: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// lifecycleRulesUsing returns the names of the lifecycle rules that send the template
+func (r *TemplateRepo) lifecycleRulesUsing(ctx context.Context, id int) ([]string, error) {
+	rows, err := r.DB.Query(ctx, `SELECT name FROM lifecycle_rules WHERE template_id = $1 ORDER BY name`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the lifecycle rules of template %d: %w", id, err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan lifecycle rule name: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func (r *TemplateRepo) UpsertVariant(ctx context.Context, variant TemplateVariant) error {
+	query := `INSERT INTO template_variants (template_id, locale, title, description, link) 
+			  VALUES ($1, $2, $3, $4, $5)
+			  ON CONFLICT (template_id, locale) DO UPDATE 
+			  SET title = EXCLUDED.title, description = EXCLUDED.description, link = EXCLUDED.link, updated_at = CURRENT_TIMESTAMP`
+	_, err := r.DB.Exec(ctx, query, variant.TemplateID, variant.Locale, variant.Title, variant.Description, variant.Link)
+	if isForeignKeyViolation(err) {
+		return pgx.ErrNoRows
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save template variant: %w", err)
+	}
+	return nil
+}
+
+func (r *TemplateRepo) DeleteVariant(ctx context.Context, templateID int, locale string) (bool, error) {
+	tag, err := r.DB.Exec(ctx, `DELETE FROM template_variants WHERE template_id = $1 AND locale = $2`, templateID, locale)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete template variant: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -2,8 +2,13 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"iter"
+	"slices"
+	"strconv"
 	"time"
 )
 
@@ -16,24 +21,73 @@ const (
 	CohortExpiredPremium    UserCohortType = "EXPIRED_PREMIUM"
 )
 
+// IsValid reports whether the type is one of the predefined cohorts.
+// An empty type is also accepted by GetCohortUsers and means all active users.
+func (c UserCohortType) IsValid() bool {
+	switch c {
+	case CohortNonPremium, CohortActivePremium, CohortPremiumNearExpiry, CohortExpiredPremium:
+		return true
+	}
+	return false
+}
+
 // The schema is this bloated because we have a pre-seeded user table,
 // and I'm too lazy to either make a separate user schema at the service level or a separate Cohorts table
 
 // Will refactor in a future iteration to make this entire project an open-source module
 type UserCohort struct {
-	UserID            int            `json:"user_id"`
-	Email             string         `json:"email"`
-	Username          string         `json:"username"`
-	FirstName         string         `json:"first_name"`
-	LastName          string         `json:"last_name"`
-	SubscriptionTier  string         `json:"subscription_tier"`
-	SubscriptionStart *time.Time     `json:"subscription_start_date"`
-	SubscriptionEnd   *time.Time     `json:"subscription_end_date"`
-	CohortType        UserCohortType `json:"cohort_type"`
-	DaysUntilExpiry   *int           `json:"days_until_expiry"`
-	LastLoginAt       *time.Time     `json:"last_login_at"`
-	NotificationPrefs string         `json:"notification_preferences"`
-	Timezone          string         `json:"timezone"`
+	UserID            int                     `json:"user_id"`
+	Email             string                  `json:"email"`
+	Username          string                  `json:"username"`
+	FirstName         string                  `json:"first_name"`
+	LastName          string                  `json:"last_name"`
+	IsPremiumUser     bool                    `json:"is_premium_user"`
+	SubscriptionTier  string                  `json:"subscription_tier"`
+	SubscriptionStart *time.Time              `json:"subscription_start_date"`
+	SubscriptionEnd   *time.Time              `json:"subscription_end_date"`
+	CohortType        UserCohortType          `json:"cohort_type"`
+	DaysUntilExpiry   *int                    `json:"days_until_expiry"`
+	LastLoginAt       *time.Time              `json:"last_login_at"`
+	NotificationPrefs NotificationPreferences `json:"notification_preferences"`
+	Timezone          string                  `json:"timezone"`
+	Locale            string                  `json:"locale"`
+	CreatedAt         time.Time               `json:"created_at"`
+}
+
+// TemplateVariableNames are the {{variables}} a template can use, filled in from the recipient
+var TemplateVariableNames = []string{
+	"user_id",
+	"email",
+	"username",
+	"first_name",
+	"last_name",
+	"subscription_tier",
+	"subscription_end_date",
+	"days_until_expiry",
+	"timezone",
+	"locale",
+}
+
+// TemplateVariables returns the values of TemplateVariableNames for the user.
+// Fields the user doesn't have, like the expiry of a user who never subscribed, are left out.
+func (u UserCohort) TemplateVariables() map[string]string {
+	values := map[string]string{
+		"user_id":           strconv.Itoa(u.UserID),
+		"email":             u.Email,
+		"username":          u.Username,
+		"first_name":        u.FirstName,
+		"last_name":         u.LastName,
+		"subscription_tier": u.SubscriptionTier,
+		"timezone":          u.Timezone,
+		"locale":            u.Locale,
+	}
+	if u.SubscriptionEnd != nil {
+		values["subscription_end_date"] = u.SubscriptionEnd.Format(time.DateOnly)
+	}
+	if u.DaysUntilExpiry != nil {
+		values["days_until_expiry"] = strconv.Itoa(*u.DaysUntilExpiry)
+	}
+	return values
 }
 
 type CohortFilters struct {
@@ -41,10 +95,108 @@ type CohortFilters struct {
 	CohortTypes      []UserCohortType `json:"cohort_types,omitempty"`
 	Timezone         *string          `json:"timezone,omitempty"`
 	IsActive         *bool            `json:"is_active,omitempty"`
+	InactiveDays     *int             `json:"inactive_days,omitempty"`   // Not logged in for this many days, or never
+	Where            *FilterExpr      `json:"where,omitempty"`           // Anything the fields above can't express
+	SavedCohortID    *int             `json:"saved_cohort_id,omitempty"` // Only users in this saved cohort as well
 	Limit            int              `json:"limit"`
 	Offset           int              `json:"offset"`
 }
 
+// Exprs returns the filters as expressions that all have to match, none when nothing is filtered on
+func (f *CohortFilters) Exprs() ([]FilterExpr, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	var exprs []FilterExpr
+	if f.SubscriptionTier != nil {
+		exprs = append(exprs, FieldFilter("subscription_tier", FilterOpEq, *f.SubscriptionTier))
+	}
+	if f.Timezone != nil {
+		exprs = append(exprs, FieldFilter("timezone", FilterOpEq, *f.Timezone))
+	}
+	if f.IsActive != nil {
+		exprs = append(exprs, FieldFilter("is_active", FilterOpEq, *f.IsActive))
+	}
+	if f.InactiveDays != nil {
+		exprs = append(exprs, AnyOf(
+			FieldFilter("last_login_at", FilterOpIsNull, nil),
+			FieldFilter("last_login_at", FilterOpOlderThan, fmt.Sprintf("%dd", *f.InactiveDays)),
+		))
+	}
+	// Users in any of the cohorts
+	if len(f.CohortTypes) > 0 {
+		var cohorts []FilterExpr
+		for _, cohortType := range f.CohortTypes {
+			if !cohortType.IsValid() {
+				return nil, fmt.Errorf("unknown cohort type: %s", cohortType)
+			}
+			cohorts = append(cohorts, CohortFilter(cohortType))
+		}
+		exprs = append(exprs, AnyOf(cohorts...))
+	}
+	if f.Where != nil {
+		exprs = append(exprs, *f.Where)
+	}
+
+	return exprs, nil
+}
+
+// Validate reports whether the filters compile
+func (f *CohortFilters) Validate() error {
+	exprs, err := f.Exprs()
+	if err != nil {
+		return err
+	}
+	for _, expr := range exprs {
+		if err := expr.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type cohortRule struct {
+	CohortType UserCohortType
+	Rule       FilterExpr
+}
+
+// cohortRules are the one definition of the built-in cohorts. A user's primary cohort is the first rule they match,
+// so every user is in exactly one of them. The SQL queries compile the rules into a single CASE (see classificationSQL)
+// and ClassifyUser evaluates the same rules in Go.
+var cohortRules = []cohortRule{
+	// Past the end date, whether or not the premium flag was cleared yet
+	{CohortExpiredPremium, AllOf(
+		FieldFilter("subscription_end_date", FilterOpNotNull, nil),
+		FieldFilter("subscription_end_date", FilterOpBefore, "0d"),
+	)},
+	{CohortNonPremium, FieldFilter("is_premium_user", FilterOpEq, false)},
+	// Ending today or within the next 30 days
+	{CohortPremiumNearExpiry, AllOf(
+		FieldFilter("subscription_end_date", FilterOpNotNull, nil),
+		Negate(FieldFilter("subscription_end_date", FilterOpAfter, "30d")),
+	)},
+	// Every other premium user, with no end date or one more than 30 days away
+	{CohortActivePremium, AnyOf(
+		FieldFilter("subscription_end_date", FilterOpIsNull, nil),
+		FieldFilter("subscription_end_date", FilterOpAfter, "30d"),
+	)},
+}
+
+// ClassifyUser returns the primary cohort of a user, the same one the SQL queries put them in at now
+func ClassifyUser(user UserCohort, now time.Time) (UserCohortType, error) {
+	for _, rule := range cohortRules {
+		matches, err := rule.Rule.Matches(user, now)
+		if err != nil {
+			return "", fmt.Errorf("invalid definition of cohort %s: %w", rule.CohortType, err)
+		}
+		if matches {
+			return rule.CohortType, nil
+		}
+	}
+	return "", nil
+}
+
 type CohortStats struct {
 	CohortType UserCohortType `json:"cohort_type"`
 	Count      int            `json:"count"`
@@ -59,8 +211,15 @@ type IUserCohortRepository interface {
 	GetCohortUsers(ctx context.Context, cohortType UserCohortType, filters *CohortFilters) ([]UserCohort, error)
 	GetCohortStats(ctx context.Context) ([]CohortStats, error)
 	GetUsersNearExpiry(ctx context.Context, daysThreshold int) ([]UserCohort, error)
-	GetUsersByCohorts(ctx context.Context, cohortTypes []UserCohortType, limit int) ([]UserCohort, error)
 	GetCohortUserCount(ctx context.Context, cohortType UserCohortType) (int, error)
+	// CountCohortUsers is GetCohortUserCount narrowed down by filters, the empty cohort type counts all active users
+	CountCohortUsers(ctx context.Context, cohortType UserCohortType, filters *CohortFilters) (int, error)
+	GetUserByID(ctx context.Context, userID int) (*UserCohort, error)
+	// FilterCohortUserIDs returns which of the users are in a cohort, Limit and Offset of the filters are ignored
+	FilterCohortUserIDs(ctx context.Context, cohortType UserCohortType, filters *CohortFilters, userIDs []int) ([]int, error)
+	// StreamCohortUsers yields the users of a cohort in batches of up to batchSize, oldest first.
+	// Only one batch is held at a time, however big the cohort. Limit and Offset of the filters are ignored.
+	StreamCohortUsers(ctx context.Context, cohortType UserCohortType, filters *CohortFilters, batchSize int) iter.Seq2[[]UserCohort, error]
 }
 
 func NewUserCohortRepo(db *pgxpool.Pool) IUserCohortRepository {
@@ -69,98 +228,84 @@ func NewUserCohortRepo(db *pgxpool.Pool) IUserCohortRepository {
 	}
 }
 
-// GetCohortUsers returns users from a specific cohort with optional filters
-func (r *UserCohortRepo) GetCohortUsers(ctx context.Context, cohortType UserCohortType, filters *CohortFilters) ([]UserCohort, error) {
-	baseQuery := `
-		SELECT 
+const cohortUserColumns = `
 			u.id,
 			u.email,
 			u.username,
 			u.first_name,
 			u.last_name,
+			u.is_premium_user,
 			u.subscription_tier,
 			u.subscription_start_date,
 			u.subscription_end_date,
 			u.last_login_at,
 			u.notification_preferences,
 			u.timezone,
+			u.locale,
+			u.created_at,
 			CASE 
 				WHEN u.subscription_end_date IS NOT NULL 
 				THEN EXTRACT(DAY FROM (u.subscription_end_date - CURRENT_DATE))::int
 				ELSE NULL 
-			END as days_until_expiry
-		FROM users u
-		WHERE u.is_active = true`
-
-	var whereConditions []string
-	var args []interface{}
-	argIndex := 1
-
-	// Add cohort-specific conditions
-	switch cohortType {
-	case CohortNonPremium:
-		whereConditions = append(whereConditions, "u.is_premium_user = false")
-		break
-	case CohortActivePremium:
-		whereConditions = append(whereConditions,
-			"u.is_premium_user = true",
-			"(u.subscription_end_date IS NULL OR u.subscription_end_date > CURRENT_DATE)")
-		break
-	case CohortPremiumNearExpiry:
-		whereConditions = append(whereConditions,
-			"u.is_premium_user = true",
-			"u.subscription_end_date IS NOT NULL",
-			"u.subscription_end_date > CURRENT_DATE",
-			"u.subscription_end_date <= CURRENT_DATE + INTERVAL '30 days'")
-		break
-	case CohortExpiredPremium:
-		whereConditions = append(whereConditions,
-			"u.subscription_end_date IS NOT NULL",
-			"u.subscription_end_date < CURRENT_DATE")
-		break
-	default:
-		break // Just get all active users. These other cases are just templates, the whole query can be customised with the filters param.
-	}
-
-	// Add optional filters
-	// Manually inject filters into the query, will refactor later to use a more structured approach
-	if filters != nil {
-		if filters.SubscriptionTier != nil {
-			whereConditions = append(whereConditions, fmt.Sprintf("u.subscription_tier = $%d", argIndex))
-			args = append(args, *filters.SubscriptionTier)
-			argIndex++
-		}
+			END as days_until_expiry`
 
-		if filters.Timezone != nil {
-			whereConditions = append(whereConditions, fmt.Sprintf("u.timezone = $%d", argIndex))
-			args = append(args, *filters.Timezone)
-			argIndex++
+// cohortCondition returns the where condition and args that select a cohort narrowed down by the filters and
+// any extra expressions, the placeholders are numbered from 1. The empty cohort type is every user.
+func cohortCondition(cohortType UserCohortType, filters *CohortFilters, extra ...FilterExpr) (string, []interface{}, error) {
+	exprs, err := filters.Exprs()
+	if err != nil {
+		return "", nil, err
+	}
+	exprs = append(exprs, extra...)
+	if cohortType != "" {
+		if !cohortType.IsValid() {
+			return "", nil, fmt.Errorf("unknown cohort type: %s", cohortType)
 		}
+		exprs = append(exprs, CohortFilter(cohortType))
+	}
+	if len(exprs) == 0 {
+		return "TRUE", nil, nil
+	}
 
-		if filters.IsActive != nil {
-			whereConditions = append(whereConditions, fmt.Sprintf("u.is_active = $%d", argIndex))
-			args = append(args, *filters.IsActive)
-			argIndex++
-		}
+	condition, args, err := AllOf(exprs...).Compile(1)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid cohort filter: %w", err)
 	}
+	return condition, args, nil
+}
 
-	// Build final query
-	query := baseQuery
-	if len(whereConditions) > 0 {
-		query += " AND " + fmt.Sprintf("(%s)", whereConditions[0])
-		for _, condition := range whereConditions[1:] {
-			query += " AND " + condition
-		}
+// savedCohortExprs returns the definition of the saved cohort the filters refer to, none if they don't refer to one
+func (r *UserCohortRepo) savedCohortExprs(ctx context.Context, filters *CohortFilters) ([]FilterExpr, error) {
+	if filters == nil || filters.SavedCohortID == nil {
+		return nil, nil
 	}
 
-	query += " ORDER BY u.created_at DESC"
+	cohort, err := getSavedCohort(ctx, r.DB, *filters.SavedCohortID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("saved cohort %d not found: %w", *filters.SavedCohortID, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get saved cohort: %w", err)
+	}
+	return cohort.Exprs()
+}
 
-	rows, err := r.DB.Query(ctx, query, args...)
+// cohortQuery builds the select over active users of a cohort, callers append ordering and paging
+func (r *UserCohortRepo) cohortQuery(ctx context.Context, columns string, cohortType UserCohortType, filters *CohortFilters) (string, []interface{}, error) {
+	saved, err := r.savedCohortExprs(ctx, filters)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query cohort users: %w", err)
+		return "", nil, err
 	}
-	defer rows.Close()
+	condition, args, err := cohortCondition(cohortType, filters, saved...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	query := "SELECT " + columns + " FROM users u WHERE u.is_active = true AND " + condition
+	return query, args, nil
+}
 
+func scanCohortUsers(rows pgx.Rows, cohortType UserCohortType) ([]UserCohort, error) {
 	var users []UserCohort
 	for rows.Next() {
 		var user UserCohort
@@ -170,12 +315,15 @@ func (r *UserCohortRepo) GetCohortUsers(ctx context.Context, cohortType UserCoho
 			&user.Username,
 			&user.FirstName,
 			&user.LastName,
+			&user.IsPremiumUser,
 			&user.SubscriptionTier,
 			&user.SubscriptionStart,
 			&user.SubscriptionEnd,
 			&user.LastLoginAt,
 			&user.NotificationPrefs,
 			&user.Timezone,
+			&user.Locale,
+			&user.CreatedAt,
 			&user.DaysUntilExpiry,
 		)
 		if err != nil {
@@ -189,22 +337,151 @@ func (r *UserCohortRepo) GetCohortUsers(ctx context.Context, cohortType UserCoho
 	return users, rows.Err()
 }
 
+// scanUserIDs reads rows of a single user id column
+func scanUserIDs(rows pgx.Rows) ([]int, error) {
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			fmt.Printf("Error scanning user id: %v\n", err)
+			continue
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// GetCohortUsers returns users from a specific cohort with optional filters
+func (r *UserCohortRepo) GetCohortUsers(ctx context.Context, cohortType UserCohortType, filters *CohortFilters) ([]UserCohort, error) {
+	query, args, err := r.cohortQuery(ctx, cohortUserColumns, cohortType, filters)
+	if err != nil {
+		return nil, err
+	}
+	argIndex := len(args) + 1
+
+	// id breaks created_at ties so that paging with limit/offset is stable
+	query += " ORDER BY u.created_at DESC, u.id DESC"
+
+	if filters != nil && filters.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT $%d", argIndex)
+		args = append(args, filters.Limit)
+		argIndex++
+	}
+
+	if filters != nil && filters.Offset > 0 {
+		query += fmt.Sprintf(" OFFSET $%d", argIndex)
+		args = append(args, filters.Offset)
+	}
+
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cohort users: %w", err)
+	}
+	defer rows.Close()
+
+	return scanCohortUsers(rows, cohortType)
+}
+
+// GetUserByID returns a single user with the same fields as the cohort queries, CohortType is left empty
+func (r *UserCohortRepo) GetUserByID(ctx context.Context, userID int) (*UserCohort, error) {
+	query := "SELECT " + cohortUserColumns + " FROM users u WHERE u.id = $1"
+
+	rows, err := r.DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	users, err := scanCohortUsers(rows, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan user %d: %w", userID, err)
+	}
+	if len(users) == 0 {
+		return nil, pgx.ErrNoRows
+	}
+	return &users[0], nil
+}
+
+func (r *UserCohortRepo) FilterCohortUserIDs(ctx context.Context, cohortType UserCohortType, filters *CohortFilters, userIDs []int) ([]int, error) {
+	query, args, err := r.cohortQuery(ctx, "u.id", cohortType, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	query += fmt.Sprintf(" AND u.id = ANY($%d)", len(args)+1)
+	args = append(args, userIDs)
+
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to filter cohort users: %w", err)
+	}
+	defer rows.Close()
+
+	return scanUserIDs(rows)
+}
+
+func (r *UserCohortRepo) StreamCohortUsers(ctx context.Context, cohortType UserCohortType, filters *CohortFilters, batchSize int) iter.Seq2[[]UserCohort, error] {
+	return func(yield func([]UserCohort, error) bool) {
+		if batchSize <= 0 {
+			yield(nil, fmt.Errorf("invalid batch size %d", batchSize))
+			return
+		}
+
+		// A saved cohort is resolved once, so the whole stream uses the same definition
+		query, args, err := r.cohortQuery(ctx, cohortUserColumns, cohortType, filters)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		argIndex := len(args) + 1
+
+		// Keyset paging, every page starts right after the last user of the previous one.
+		// Unlike an offset this costs the same on the last page as on the first, and nobody is skipped or
+		// repeated when users are added in between (new users come last).
+		firstPage := query + fmt.Sprintf(" ORDER BY u.created_at, u.id LIMIT $%d", argIndex)
+		nextPage := query + fmt.Sprintf(" AND (u.created_at, u.id) > ($%d, $%d) ORDER BY u.created_at, u.id LIMIT $%d", argIndex, argIndex+1, argIndex+2)
+
+		var last *UserCohort
+		for {
+			var rows pgx.Rows
+			if last == nil {
+				rows, err = r.DB.Query(ctx, firstPage, append(slices.Clip(args), batchSize)...)
+			} else {
+				rows, err = r.DB.Query(ctx, nextPage, append(slices.Clip(args), last.CreatedAt, last.UserID, batchSize)...)
+			}
+			if err != nil {
+				yield(nil, fmt.Errorf("failed to stream cohort users: %w", err))
+				return
+			}
+			users, err := scanCohortUsers(rows, cohortType)
+			rows.Close()
+			if err != nil {
+				yield(nil, fmt.Errorf("failed to stream cohort users: %w", err))
+				return
+			}
+
+			if len(users) == 0 || !yield(users, nil) || len(users) < batchSize {
+				return
+			}
+			last = &users[len(users)-1]
+		}
+	}
+}
+
 func (r *UserCohortRepo) GetCohortStats(ctx context.Context) ([]CohortStats, error) {
+	classification, args, err := classificationSQL(1)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
 		WITH cohort_counts AS (
 			SELECT 
-				CASE 
-					WHEN is_premium_user = false THEN 'NON_PREMIUM'
-					WHEN is_premium_user = true AND (subscription_end_date IS NULL OR subscription_end_date > CURRENT_DATE) THEN 'ACTIVE_PREMIUM'
-					WHEN is_premium_user = true AND subscription_end_date IS NOT NULL 
-						 AND subscription_end_date > CURRENT_DATE 
-						 AND subscription_end_date <= CURRENT_DATE + INTERVAL '30 days' THEN 'PREMIUM_NEAR_EXPIRY'
-					WHEN subscription_end_date IS NOT NULL AND subscription_end_date < CURRENT_DATE THEN 'EXPIRED_PREMIUM'
-					ELSE 'OTHER'
-				END as cohort_type,
+				` + classification + ` as cohort_type,
 				COUNT(*) as count
-			FROM users 
-			WHERE is_active = true
+			FROM users u
+			WHERE u.is_active = true
 			GROUP BY 1
 		),
 		total_users AS (
@@ -218,7 +495,7 @@ func (r *UserCohortRepo) GetCohortStats(ctx context.Context) ([]CohortStats, err
 		CROSS JOIN total_users tu
 		ORDER BY cc.count DESC`
 
-	rows, err := r.DB.Query(ctx, query)
+	rows, err := r.DB.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get cohort stats: %w", err)
 	}
@@ -240,90 +517,50 @@ func (r *UserCohortRepo) GetCohortStats(ctx context.Context) ([]CohortStats, err
 	return stats, rows.Err()
 }
 
+// endsWithin matches subscriptions ending no later than days from today, already ended ones included
+func endsWithin(days int) FilterExpr {
+	return Negate(FieldFilter("subscription_end_date", FilterOpAfter, fmt.Sprintf("%dd", days)))
+}
+
+// GetUsersNearExpiry returns the users of the premium_near_expiry cohort whose subscription ends within daysThreshold
+// days, soonest first. It is the cohort's own rule narrowed down, so it agrees with the cohort on the users ending
+// today and on the premium flag, and a threshold past the cohort's 30 days finds no more users than 30 does.
 func (r *UserCohortRepo) GetUsersNearExpiry(ctx context.Context, daysThreshold int) ([]UserCohort, error) {
-	query := `
-		SELECT 
-			u.id, u.email, u.username, u.first_name, u.last_name,
-			u.subscription_tier, u.subscription_start_date, u.subscription_end_date,
-			u.last_login_at, u.notification_preferences, u.timezone,
-			EXTRACT(DAY FROM (u.subscription_end_date - CURRENT_DATE))::int as days_until_expiry
-		FROM users u
-		WHERE u.is_active = true 
-		AND u.is_premium_user = true
-		AND u.subscription_end_date IS NOT NULL
-		AND u.subscription_end_date > CURRENT_DATE
-		AND u.subscription_end_date <= CURRENT_DATE + INTERVAL '%d days'
-		ORDER BY u.subscription_end_date ASC`
-
-	formattedQuery := fmt.Sprintf(query, daysThreshold)
-	rows, err := r.DB.Query(ctx, formattedQuery)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get users near expiry: %w", err)
+	if daysThreshold < 0 {
+		return nil, fmt.Errorf("invalid expiry threshold: %d days", daysThreshold)
 	}
-	defer rows.Close()
 
-	var users []UserCohort
-	for rows.Next() {
-		var user UserCohort
-		err := rows.Scan(
-			&user.UserID, &user.Email, &user.Username, &user.FirstName, &user.LastName,
-			&user.SubscriptionTier, &user.SubscriptionStart, &user.SubscriptionEnd,
-			&user.LastLoginAt, &user.NotificationPrefs, &user.Timezone,
-			&user.DaysUntilExpiry,
-		)
-		if err != nil {
-			fmt.Printf("Error scanning user near expiry: %v\n", err)
-			continue
-		}
-		user.CohortType = CohortPremiumNearExpiry
-		users = append(users, user)
+	condition, args, err := cohortCondition(CohortPremiumNearExpiry, nil, endsWithin(daysThreshold))
+	if err != nil {
+		return nil, err
 	}
 
-	return users, rows.Err()
-}
-
-func (r *UserCohortRepo) GetUsersByCohorts(ctx context.Context, cohortTypes []UserCohortType, limit int) ([]UserCohort, error) {
-	var allUsers []UserCohort
+	query := "SELECT " + cohortUserColumns + " FROM users u WHERE u.is_active = true AND " + condition + " ORDER BY u.subscription_end_date ASC"
 
-	for _, cohortType := range cohortTypes {
-		filters := &CohortFilters{
-			Limit: limit,
-		}
-
-		users, err := r.GetCohortUsers(ctx, cohortType, filters)
-		if err != nil {
-			fmt.Printf("Error getting users for cohort %s: %v\n", cohortType, err)
-			continue
-		}
-
-		allUsers = append(allUsers, users...)
+	rows, err := r.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users near expiry: %w", err)
 	}
+	defer rows.Close()
 
-	return allUsers, nil
+	return scanCohortUsers(rows, CohortPremiumNearExpiry)
 }
 
 func (r *UserCohortRepo) GetCohortUserCount(ctx context.Context, cohortType UserCohortType) (int, error) {
-	var query string
-
-	switch cohortType {
-	case CohortNonPremium:
-		query = "SELECT COUNT(*) FROM users WHERE is_active = true AND is_premium_user = false"
-	case CohortActivePremium:
-		query = `SELECT COUNT(*) FROM users WHERE is_active = true AND is_premium_user = true 
-				 AND (subscription_end_date IS NULL OR subscription_end_date > CURRENT_DATE)`
-	case CohortPremiumNearExpiry:
-		query = `SELECT COUNT(*) FROM users WHERE is_active = true AND is_premium_user = true 
-				 AND subscription_end_date IS NOT NULL AND subscription_end_date > CURRENT_DATE 
-				 AND subscription_end_date <= CURRENT_DATE + INTERVAL '30 days'`
-	case CohortExpiredPremium:
-		query = `SELECT COUNT(*) FROM users WHERE is_active = true 
-				 AND subscription_end_date IS NOT NULL AND subscription_end_date < CURRENT_DATE`
-	default:
+	if !cohortType.IsValid() {
 		return 0, fmt.Errorf("unknown cohort type: %s", cohortType)
 	}
+	return r.CountCohortUsers(ctx, cohortType, nil)
+}
+
+func (r *UserCohortRepo) CountCohortUsers(ctx context.Context, cohortType UserCohortType, filters *CohortFilters) (int, error) {
+	query, args, err := r.cohortQuery(ctx, "COUNT(*)", cohortType, filters)
+	if err != nil {
+		return 0, err
+	}
 
 	var count int
-	err := r.DB.QueryRow(ctx, query).Scan(&count)
+	err = r.DB.QueryRow(ctx, query, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get cohort count: %w", err)
 	}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"log"
+	"time"
+)
+
+// Every cache key starts with the current generation, invalidating bumps it so the old keys are never read again
+const cohortCacheGenerationKey = "pingmemaybe:cohorts:generation"
+
+type freshCountsKey struct{}
+
+// WithFreshCounts makes the cohort counts read with ctx skip the cache, and refresh it with what they read
+func WithFreshCounts(ctx context.Context) context.Context {
+	return context.WithValue(ctx, freshCountsKey{}, true)
+}
+
+func wantsFreshCounts(ctx context.Context) bool {
+	fresh, _ := ctx.Value(freshCountsKey{}).(bool)
+	return fresh
+}
+
+// CachedUserCohortRepo caches the full-scan counts of a user cohort repository in Redis:
+// GetCohortStats, GetCohortUserCount and CountCohortUsers. Everything else goes straight to the repository.
+// Counts can be up to TTL old. When Redis is unavailable the counts are read from the repository.
+type CachedUserCohortRepo struct {
+	IUserCohortRepository
+	Redis *redis.Client
+	TTL   time.Duration
+}
+
+type ICachedUserCohortRepository interface {
+	IUserCohortRepository
+	// InvalidateCounts drops every cached count, for changes that make them wrong before their TTL is up
+	InvalidateCounts(ctx context.Context) error
+}
+
+func NewCachedUserCohortRepo(repository IUserCohortRepository, redis *redis.Client, ttl time.Duration) ICachedUserCohortRepository {
+	return &CachedUserCohortRepo{
+		IUserCohortRepository: repository,
+		Redis:                 redis,
+		TTL:                   ttl,
+	}
+}
+
+func (r *CachedUserCohortRepo) GetCohortStats(ctx context.Context) ([]CohortStats, error) {
+	return cachedCount(ctx, r, "stats", func() ([]CohortStats, error) {
+		return r.IUserCohortRepository.GetCohortStats(ctx)
+	})
+}
+
+func (r *CachedUserCohortRepo) GetCohortUserCount(ctx context.Context, cohortType UserCohortType) (int, error) {
+	return cachedCount(ctx, r, "count:"+string(cohortType), func() (int, error) {
+		return r.IUserCohortRepository.GetCohortUserCount(ctx, cohortType)
+	})
+}
+
+func (r *CachedUserCohortRepo) CountCohortUsers(ctx context.Context, cohortType UserCohortType, filters *CohortFilters) (int, error) {
+	// Limit and Offset don't change a count, they are left out of the key
+	var key CohortFilters
+	if filters != nil {
+		key = *filters
+		key.Limit, key.Offset = 0, 0
+	}
+	encoded, err := json.Marshal(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode cohort filters: %w", err)
+	}
+
+	return cachedCount(ctx, r, fmt.Sprintf("count:%s:%x", cohortType, sha256.Sum256(encoded)), func() (int, error) {
+		return r.IUserCohortRepository.CountCohortUsers(ctx, cohortType, filters)
+	})
+}
+
+func (r *CachedUserCohortRepo) InvalidateCounts(ctx context.Context) error {
+	if err := r.Redis.Incr(ctx, cohortCacheGenerationKey).Err(); err != nil {
+		return fmt.Errorf("failed to invalidate cohort counts: %w", err)
+	}
+	return nil
+}
+
+// cachedCount returns the cached value of the key, or loads and caches it
+func cachedCount[T any](ctx context.Context, r *CachedUserCohortRepo, name string, load func() (T, error)) (T, error) {
+	generation, err := r.Redis.Get(ctx, cohortCacheGenerationKey).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		log.Printf("cohort cache unavailable, reading %s from the database: %v", name, err)
+		return load()
+	}
+	key := fmt.Sprintf("pingmemaybe:cohorts:%d:%s", generation, name)
+
+	if !wantsFreshCounts(ctx) {
+		cached, err := r.Redis.Get(ctx, key).Bytes()
+		if err == nil {
+			var value T
+			if err := json.Unmarshal(cached, &value); err == nil {
+				return value, nil
+			}
+		} else if !errors.Is(err, redis.Nil) {
+			log.Printf("cohort cache unavailable, reading %s from the database: %v", name, err)
+			return load()
+		}
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+	encoded, err := json.Marshal(value)
+	if err == nil {
+		err = r.Redis.Set(ctx, key, encoded, r.TTL).Err()
+	}
+	if err != nil {
+		log.Printf("failed to cache cohort %s: %v", name, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,159 @@
+package models
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"os"
+	"testing"
+	"time"
+)
+
+// classificationCases are users around the cohort boundaries. subscription_end_date is a TIMESTAMP and the rules
+// compare it to the current date plus whole days, so the time of day on the boundary days matters.
+var classificationCases = []struct {
+	name    string
+	premium bool
+	end     *time.Duration
+	want    UserCohortType
+}{
+	{"premium ending at the start of today", true, endsAt(0, 0, 0), CohortPremiumNearExpiry},
+	{"premium ending at 09:00 today", true, endsAt(0, 9, 0), CohortPremiumNearExpiry},
+	{"premium ending in 30 days at midnight", true, endsAt(30, 0, 0), CohortPremiumNearExpiry},
+	{"premium ending in 30 days at 00:01", true, endsAt(30, 0, 1), CohortActivePremium},
+	{"premium ending in 30 days at 14:00", true, endsAt(30, 14, 0), CohortActivePremium},
+	{"premium ending in 31 days", true, endsAt(31, 0, 0), CohortActivePremium},
+	{"premium ended yesterday", true, endsAt(-1, 0, 0), CohortExpiredPremium},
+	{"premium ended at 23:59 yesterday", true, endsAt(-1, 23, 59), CohortExpiredPremium},
+	{"premium without end date", true, nil, CohortActivePremium},
+	{"non-premium ended yesterday", false, endsAt(-1, 0, 0), CohortExpiredPremium},
+	{"non-premium ending at 09:00 today", false, endsAt(0, 9, 0), CohortNonPremium},
+	{"non-premium ending in 10 days", false, endsAt(10, 0, 0), CohortNonPremium},
+	{"non-premium without end date", false, nil, CohortNonPremium},
+}
+
+// endsAt is an end date days from today at hour:minute, as an offset from the start of today
+func endsAt(days int, hour int, minute int) *time.Duration {
+	offset := time.Duration(days)*24*time.Hour + time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute
+	return &offset
+}
+
+// classificationUser builds the user of a case as pgx scans it, the end date a UTC wall clock time
+func classificationUser(premium bool, end *time.Duration, now time.Time) UserCohort {
+	user := UserCohort{IsPremiumUser: premium}
+	if end != nil {
+		now = now.UTC()
+		endAt := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(*end)
+		user.SubscriptionEnd = &endAt
+	}
+	return user
+}
+
+func TestClassifyUser(t *testing.T) {
+	// Late in the day, so a comparison against the current time instead of the current date would show
+	now := time.Date(2026, time.March, 15, 23, 30, 0, 0, time.UTC)
+
+	for _, tt := range classificationCases {
+		t.Run(tt.name, func(t *testing.T) {
+			user := classificationUser(tt.premium, tt.end, now)
+
+			got, err := ClassifyUser(user, now)
+			if err != nil {
+				t.Fatalf("ClassifyUser: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ClassifyUser = %s, want %s", got, tt.want)
+			}
+
+			// Exactly one cohort filter matches, the one the user is classified into
+			for _, rule := range cohortRules {
+				matches, err := CohortFilter(rule.CohortType).Matches(user, now)
+				if err != nil {
+					t.Fatalf("Matches(%s): %v", rule.CohortType, err)
+				}
+				if matches != (rule.CohortType == tt.want) {
+					t.Errorf("cohort filter %s matches = %v", rule.CohortType, matches)
+				}
+			}
+		})
+	}
+}
+
+func TestUsersNearExpiryFilter(t *testing.T) {
+	now := time.Date(2026, time.March, 15, 23, 30, 0, 0, time.UTC)
+	filter := AllOf(CohortFilter(CohortPremiumNearExpiry), endsWithin(7))
+
+	tests := []struct {
+		name    string
+		premium bool
+		end     *time.Duration
+		want    bool
+	}{
+		{"ending at 09:00 today", true, endsAt(0, 9, 0), true},
+		{"ending in 7 days at midnight", true, endsAt(7, 0, 0), true},
+		{"ending in 7 days at 14:00", true, endsAt(7, 14, 0), false},
+		{"ending in 8 days", true, endsAt(8, 0, 0), false},
+		{"ended yesterday", true, endsAt(-1, 0, 0), false},
+		{"without end date", true, nil, false},
+		{"non-premium ending in 3 days", false, endsAt(3, 0, 0), false},
+	}
+	for _, tt := range tests {
+		matches, err := filter.Matches(classificationUser(tt.premium, tt.end, now), now)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if matches != tt.want {
+			t.Errorf("%s: matches = %v, want %v", tt.name, matches, tt.want)
+		}
+	}
+}
+
+// TestClassificationSQLMatchesClassifyUser runs the compiled classification on Postgres and checks that it puts
+// every case in the same cohort as ClassifyUser. Set PINGMEMAYBE_TEST_DATABASE_URL to run it.
+func TestClassificationSQLMatchesClassifyUser(t *testing.T) {
+	databaseURL := os.Getenv("PINGMEMAYBE_TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("PINGMEMAYBE_TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	// Matches compares timestamps as UTC wall clock times
+	if _, err := conn.Exec(ctx, "SET TIME ZONE 'UTC'"); err != nil {
+		t.Fatalf("failed to set the time zone: %v", err)
+	}
+	var now time.Time
+	if err := conn.QueryRow(ctx, "SELECT CURRENT_TIMESTAMP").Scan(&now); err != nil {
+		t.Fatalf("failed to read the current time: %v", err)
+	}
+
+	// $1 and $2 are the user's columns, typed like in the users table
+	classification, args, err := classificationSQL(3)
+	if err != nil {
+		t.Fatalf("classificationSQL: %v", err)
+	}
+	query := "SELECT " + classification + " FROM (SELECT $1::boolean AS is_premium_user, $2::timestamp AS subscription_end_date) u"
+
+	for _, tt := range classificationCases {
+		t.Run(tt.name, func(t *testing.T) {
+			user := classificationUser(tt.premium, tt.end, now)
+
+			var got string
+			err := conn.QueryRow(ctx, query, append([]interface{}{user.IsPremiumUser, user.SubscriptionEnd}, args...)...).Scan(&got)
+			if err != nil {
+				t.Fatalf("failed to classify: %v", err)
+			}
+			want, err := ClassifyUser(user, now)
+			if err != nil {
+				t.Fatalf("ClassifyUser: %v", err)
+			}
+			if UserCohortType(got) != want || want != tt.want {
+				t.Errorf("SQL = %s, ClassifyUser = %s, want %s", got, want, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package dto
+
+import "PingMeMaybe/libs/db/models"
+
+// DispatchNotificationDTO is the payload of a DispatchNotification task.
+// Transactional notifications only fill the embedded DTO, the broadcast fan-out
+// also attaches the broadcast notification and the recipient picked from the cohort.
+type DispatchNotificationDTO struct {
+	PostNotificationDTO
+	NotificationID int                `json:"notification_id,omitempty"`
+	Recipient      *models.UserCohort `json:"recipient,omitempty"`
+}
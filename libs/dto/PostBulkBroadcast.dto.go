@@ -0,0 +1,80 @@
+package dto
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/utils"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type PostBulkBroadcastDTO struct {
+	Notification PostNotificationDTO   `json:"notification"`
+	CohortType   models.UserCohortType `json:"cohort_type"`
+	Filters      *models.CohortFilters `json:"filters,omitempty"`
+	// Optional, deliver by each recipient's local time
+	LocalDelivery *LocalDeliveryDTO `json:"local_delivery,omitempty"`
+	// Optional, users of the cohort to leave out. Users on the suppression list are always left out.
+	Exclusions *models.BroadcastExclusions `json:"exclusions,omitempty"`
+}
+
+// BulkBroadcastTaskDTO is the payload of an InitiateBulkBroadcast task,
+// the request plus the notification row the gateway saved for it
+type BulkBroadcastTaskDTO struct {
+	NotificationID int `json:"notification_id"`
+	PostBulkBroadcastDTO
+}
+
+// BroadcastChunkTaskDTO is the payload of a ProcessBroadcastChunk task
+type BroadcastChunkTaskDTO struct {
+	ChunkID int `json:"chunk_id"`
+	BulkBroadcastTaskDTO
+}
+
+// LocalDeliveryDTO times every recipient's delivery by their own users.timezone,
+// so a global campaign doesn't go out in the middle of the night for half the audience
+type LocalDeliveryDTO struct {
+	// "HH:MM", deliver at the next occurrence of this local time
+	DeliverAt string `json:"deliver_at,omitempty"`
+	// "HH:MM" window, e.g. 22:00 to 08:00, deliveries falling inside it wait until it ends
+	QuietHoursStart string `json:"quiet_hours_start,omitempty"`
+	QuietHoursEnd   string `json:"quiet_hours_end,omitempty"`
+}
+
+func (l LocalDeliveryDTO) Validate() error {
+	if l.DeliverAt != "" {
+		if _, err := utils.ParseClockTime(l.DeliverAt); err != nil {
+			return fmt.Errorf("deliver_at: %w", err)
+		}
+	}
+	if (l.QuietHoursStart == "") != (l.QuietHoursEnd == "") {
+		return errors.New("quiet_hours_start and quiet_hours_end must be set together")
+	}
+	if l.QuietHoursStart != "" {
+		if _, err := utils.ParseClockTime(l.QuietHoursStart); err != nil {
+			return fmt.Errorf("quiet_hours_start: %w", err)
+		}
+		if _, err := utils.ParseClockTime(l.QuietHoursEnd); err != nil {
+			return fmt.Errorf("quiet_hours_end: %w", err)
+		}
+	}
+	return nil
+}
+
+// DeliveryTime returns when a recipient in timezone should get the notification, now if there is nothing to wait for
+func (l LocalDeliveryDTO) DeliveryTime(now time.Time, timezone string) time.Time {
+	loc := utils.LoadLocation(timezone)
+	at := now
+
+	if deliverAt, err := utils.ParseClockTime(l.DeliverAt); err == nil {
+		at = utils.NextLocalTime(now, loc, deliverAt)
+	}
+
+	start, startErr := utils.ParseClockTime(l.QuietHoursStart)
+	end, endErr := utils.ParseClockTime(l.QuietHoursEnd)
+	if startErr == nil && endErr == nil {
+		at = utils.DeferQuietHours(at, loc, start, end)
+	}
+
+	return at
+}
@@ -0,0 +1,48 @@
+package dto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalDeliveryTime(t *testing.T) {
+	// 21:30 in Berlin, 06:00 the next day in Tokyo
+	now := time.Date(2026, time.June, 10, 19, 30, 0, 0, time.UTC)
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("failed to load Europe/Berlin: %v", err)
+	}
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatalf("failed to load Asia/Tokyo: %v", err)
+	}
+	quietNights := LocalDeliveryDTO{QuietHoursStart: "22:00", QuietHoursEnd: "08:00"}
+
+	tests := []struct {
+		name     string
+		delivery LocalDeliveryDTO
+		timezone string
+		want     time.Time
+	}{
+		{"nothing to wait for", LocalDeliveryDTO{}, "Europe/Berlin", now},
+		{"deliver_at later today", LocalDeliveryDTO{DeliverAt: "22:15"}, "Europe/Berlin",
+			time.Date(2026, time.June, 10, 22, 15, 0, 0, berlin)},
+		{"deliver_at already passed today", LocalDeliveryDTO{DeliverAt: "09:00"}, "Europe/Berlin",
+			time.Date(2026, time.June, 11, 9, 0, 0, 0, berlin)},
+		{"outside quiet hours", quietNights, "Europe/Berlin", now},
+		{"inside quiet hours", quietNights, "Asia/Tokyo",
+			time.Date(2026, time.June, 11, 8, 0, 0, 0, tokyo)},
+		{"deliver_at inside quiet hours", LocalDeliveryDTO{DeliverAt: "23:00", QuietHoursStart: "22:00", QuietHoursEnd: "08:00"}, "Europe/Berlin",
+			time.Date(2026, time.June, 11, 8, 0, 0, 0, berlin)},
+		{"unknown timezone is UTC", LocalDeliveryDTO{DeliverAt: "09:00"}, "Nowhere/Special",
+			time.Date(2026, time.June, 11, 9, 0, 0, 0, time.UTC)},
+		{"no timezone is UTC", quietNights, "", now},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.delivery.DeliveryTime(now, tt.timezone); !got.Equal(tt.want) {
+				t.Errorf("DeliveryTime = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package dto
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"fmt"
+)
+
+// Sample size of a cohort preview when none is given, and the largest one accepted
+const (
+	DefaultPreviewSampleSize = 10
+	MaxPreviewSampleSize     = 100
+)
+
+// PostCohortPreviewDTO takes the same audience as a broadcast, to check it before sending
+type PostCohortPreviewDTO struct {
+	CohortType models.UserCohortType `json:"cohort_type"`
+	Filters    *models.CohortFilters `json:"filters,omitempty"`
+	SampleSize int                   `json:"sample_size"`
+}
+
+func (p PostCohortPreviewDTO) Validate() error {
+	if p.CohortType != "" && !p.CohortType.IsValid() {
+		return fmt.Errorf("unknown cohort type: %s", p.CohortType)
+	}
+	if p.SampleSize < 0 || p.SampleSize > MaxPreviewSampleSize {
+		return fmt.Errorf("sample_size must be between 0 and %d", MaxPreviewSampleSize)
+	}
+	return p.Filters.Validate()
+}
@@ -0,0 +1,49 @@
+package dto
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"errors"
+	"fmt"
+)
+
+type PostLifecycleRuleDTO struct {
+	Name          string                `json:"name"`
+	CohortType    models.UserCohortType `json:"cohort_type"`
+	Filters       *models.CohortFilters `json:"filters"`
+	TemplateID    int                   `json:"template_id"`
+	ChannelID     *int                  `json:"channel_id"`
+	CooldownHours int                   `json:"cooldown_hours"`
+	// Defaults to true
+	Enabled *bool `json:"enabled"`
+}
+
+func (r PostLifecycleRuleDTO) Validate() error {
+	if r.Name == "" {
+		return errors.New("name is required")
+	}
+	if r.CohortType != "" && !r.CohortType.IsValid() {
+		return fmt.Errorf("unknown cohort type: %s", r.CohortType)
+	}
+	if r.TemplateID == 0 {
+		return errors.New("template_id is required")
+	}
+	if r.CooldownHours <= 0 {
+		return errors.New("cooldown_hours must be positive")
+	}
+	if r.Filters != nil && r.Filters.InactiveDays != nil && *r.Filters.InactiveDays <= 0 {
+		return errors.New("filters.inactive_days must be positive")
+	}
+	return r.Filters.Validate()
+}
+
+func (r PostLifecycleRuleDTO) ToRule() models.LifecycleRule {
+	return models.LifecycleRule{
+		Name:          r.Name,
+		CohortType:    r.CohortType,
+		Filters:       r.Filters,
+		TemplateID:    r.TemplateID,
+		ChannelID:     r.ChannelID,
+		CooldownHours: r.CooldownHours,
+		Enabled:       r.Enabled == nil || *r.Enabled,
+	}
+}
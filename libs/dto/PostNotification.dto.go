@@ -1,8 +1,58 @@
 package dto
 
+import (
+	"PingMeMaybe/libs/messagePatterns"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
 type PostNotificationDTO struct {
 	Id          int    `json:"id"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
 	Link        string `json:"link"`
+	// Title, description and link are then rendered from the template for every recipient, see models.Template
+	TemplateID *int `json:"template_id,omitempty"`
+	// One of the models.Channel* ids, the processor's default channel is used when empty
+	ChannelID *int `json:"channel_id,omitempty"`
+	// Channels tried in order when the recipient opted out of ChannelID
+	FallbackChannelIDs []int `json:"fallback_channel_ids,omitempty"`
+	// Endpoint the webhook channel POSTs to, falls back to WEBHOOK_DEFAULT_URL
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Picks the asynq queue, see messagePatterns.QueueForPriority. Defaults depend on the endpoint.
+	Priority messagePatterns.Priority `json:"priority,omitempty"`
+	// Optional schedule, the notification is held back until SendAt and dropped if not delivered by ExpiresAt
+	SendAt    *time.Time `json:"send_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Recipient of a transactional notification, broadcasts pick their recipients from the cohort
+	UserID *int `json:"user_id,omitempty"`
+}
+
+// Validate checks the fields the gateway can't leave to the processor to reject
+func (n PostNotificationDTO) Validate() error {
+	if n.Priority != "" && !n.Priority.IsValid() {
+		return fmt.Errorf("unknown priority: %s", n.Priority)
+	}
+	if n.WebhookURL != "" {
+		parsed, err := url.Parse(n.WebhookURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return errors.New("webhook_url must be an absolute http(s) url")
+		}
+	}
+	if n.ExpiresAt != nil {
+		if n.ExpiresAt.Before(time.Now()) {
+			return errors.New("expires_at is in the past")
+		}
+		if n.SendAt != nil && !n.ExpiresAt.After(*n.SendAt) {
+			return errors.New("expires_at must be after send_at")
+		}
+	}
+	return nil
+}
+
+// IsScheduled reports whether the notification is to be sent later rather than right away
+func (n PostNotificationDTO) IsScheduled() bool {
+	return n.SendAt != nil && n.SendAt.After(time.Now())
 }
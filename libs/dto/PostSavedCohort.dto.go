@@ -0,0 +1,41 @@
+package dto
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"errors"
+	"fmt"
+)
+
+type PostSavedCohortDTO struct {
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	CohortType  models.UserCohortType `json:"cohort_type"`
+	Filters     *models.CohortFilters `json:"filters"`
+}
+
+func (c PostSavedCohortDTO) Validate() error {
+	if c.Name == "" {
+		return errors.New("name is required")
+	}
+	if c.CohortType != "" && !c.CohortType.IsValid() {
+		return fmt.Errorf("unknown cohort type: %s", c.CohortType)
+	}
+	if c.Filters != nil && c.Filters.InactiveDays != nil && *c.Filters.InactiveDays <= 0 {
+		return errors.New("filters.inactive_days must be positive")
+	}
+	// Checks the filters, and that they don't refer to another saved cohort
+	_, err := c.ToCohort().Exprs()
+	if err != nil {
+		return err
+	}
+	return c.Filters.Validate()
+}
+
+func (c PostSavedCohortDTO) ToCohort() models.SavedCohort {
+	return models.SavedCohort{
+		Name:        c.Name,
+		Description: c.Description,
+		CohortType:  c.CohortType,
+		Filters:     c.Filters,
+	}
+}
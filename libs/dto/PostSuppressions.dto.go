@@ -0,0 +1,29 @@
+package dto
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Most users suppressed by one request
+const MaxSuppressionsPerRequest = 10000
+
+type PostSuppressionsDTO struct {
+	UserIDs []int  `json:"user_ids"`
+	Reason  string `json:"reason"`
+}
+
+func (s PostSuppressionsDTO) Validate() error {
+	if len(s.UserIDs) == 0 {
+		return errors.New("user_ids is required")
+	}
+	if len(s.UserIDs) > MaxSuppressionsPerRequest {
+		return fmt.Errorf("at most %d users can be suppressed at once", MaxSuppressionsPerRequest)
+	}
+	for _, userID := range s.UserIDs {
+		if userID <= 0 {
+			return fmt.Errorf("invalid user id %d", userID)
+		}
+	}
+	return nil
+}
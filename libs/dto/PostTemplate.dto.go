@@ -0,0 +1,86 @@
+package dto
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/utils"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type PostTemplateDTO struct {
+	Name     string                   `json:"name"`
+	Variants []PostTemplateVariantDTO `json:"variants"`
+}
+
+type PostTemplateVariantDTO struct {
+	Locale      string `json:"locale"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Link        string `json:"link"`
+}
+
+// Validate rejects templates the processor could never render, like a typo in a variable name.
+// Every other locale falls back to defaultLocale, so a template needs a variant for it.
+func (t PostTemplateDTO) Validate(defaultLocale string) error {
+	if t.Name == "" {
+		return errors.New("name is required")
+	}
+	if len(t.Variants) == 0 {
+		return errors.New("at least one variant is required")
+	}
+
+	seen := map[string]bool{}
+	for _, variant := range t.Variants {
+		if err := variant.Validate(); err != nil {
+			return err
+		}
+		locale := utils.NormalizeLocale(variant.Locale)
+		if seen[locale] {
+			return fmt.Errorf("more than one variant for locale %s", locale)
+		}
+		seen[locale] = true
+	}
+	if !seen[defaultLocale] {
+		return fmt.Errorf("a variant for the default locale %s is required", defaultLocale)
+	}
+	return nil
+}
+
+func (t PostTemplateDTO) ToTemplate() models.Template {
+	template := models.Template{Name: t.Name}
+	for _, variant := range t.Variants {
+		template.Variants = append(template.Variants, variant.ToVariant())
+	}
+	return template
+}
+
+func (v PostTemplateVariantDTO) Validate() error {
+	if !utils.IsValidLocale(utils.NormalizeLocale(v.Locale)) {
+		return fmt.Errorf("invalid locale %q, expected a tag like en, de or pt-BR", v.Locale)
+	}
+	if v.Title == "" {
+		return fmt.Errorf("title of the %s variant is required", v.Locale)
+	}
+	for _, text := range []string{v.Title, v.Description, v.Link} {
+		names, err := utils.TemplateVariables(text)
+		if err != nil {
+			return fmt.Errorf("%s variant: %w", v.Locale, err)
+		}
+		for _, name := range names {
+			if !slices.Contains(models.TemplateVariableNames, name) {
+				return fmt.Errorf("%s variant: unknown template variable {{%s}}, available: %v", v.Locale, name, models.TemplateVariableNames)
+			}
+		}
+	}
+	return nil
+}
+
+func (v PostTemplateVariantDTO) ToVariant() models.TemplateVariant {
+	return models.TemplateVariant{
+		Locale:      utils.NormalizeLocale(v.Locale),
+		Title:       v.Title,
+		Description: v.Description,
+		Link:        v.Link,
+	}
+}
@@ -3,4 +3,5 @@ package messagePatterns
 const (
 	DispatchNotification  = "DispatchNotification"
 	InitiateBulkBroadcast = "InitiateBulkBroadcast"
+	ProcessBroadcastChunk = "ProcessBroadcastChunk"
 )
@@ -0,0 +1,39 @@
+package messagePatterns
+
+// Asynq queues, weighted in the processor so critical tasks are picked up first
+const (
+	QueueCritical = "critical"
+	QueueDefault  = "default"
+	QueueLow      = "low"
+)
+
+// Priority of a notification, decides which queue its tasks go to
+type Priority string
+
+const (
+	// OTPs, password resets... anything a user is waiting on
+	PriorityTransactional Priority = "transactional"
+	PriorityNormal        Priority = "normal"
+	// Campaigns and other bulk sends
+	PriorityBulk Priority = "bulk"
+)
+
+func (p Priority) IsValid() bool {
+	switch p {
+	case PriorityTransactional, PriorityNormal, PriorityBulk:
+		return true
+	}
+	return false
+}
+
+// QueueForPriority is the routing policy: transactional notifications jump the line on the critical queue,
+// bulk sends stay on the low queue so a large broadcast can't starve them
+func QueueForPriority(priority Priority) string {
+	switch priority {
+	case PriorityTransactional:
+		return QueueCritical
+	case PriorityBulk:
+		return QueueLow
+	}
+	return QueueDefault
+}
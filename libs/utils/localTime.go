@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+)
+
+// ClockTime is a time of day, without a date or timezone
+type ClockTime struct {
+	Hour   int
+	Minute int
+}
+
+// ParseClockTime parses a 24h "HH:MM" time of day
+func ParseClockTime(value string) (ClockTime, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return ClockTime{}, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return ClockTime{Hour: parsed.Hour(), Minute: parsed.Minute()}, nil
+}
+
+func (c ClockTime) minutes() int {
+	return c.Hour*60 + c.Minute
+}
+
+// on returns the clock time on the date of day, in day's location
+func (c ClockTime) on(day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), c.Hour, c.Minute, 0, 0, day.Location())
+}
+
+// LoadLocation returns the location of an IANA timezone name, unknown or empty names fall back to UTC
+func LoadLocation(timezone string) *time.Location {
+	if timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// NextLocalTime returns the next moment, from now on, when the clock in loc shows at
+func NextLocalTime(now time.Time, loc *time.Location, at ClockTime) time.Time {
+	local := now.In(loc)
+	next := at.on(local)
+	if next.Before(local) {
+		next = at.on(local.AddDate(0, 0, 1))
+	}
+	return next
+}
+
+// DeferQuietHours moves t to the end of the quiet hours if it falls inside them in loc.
+// The window is [start, end) and may wrap midnight, e.g. 22:00 to 08:00. An equal start and end is an empty window.
+func DeferQuietHours(t time.Time, loc *time.Location, start ClockTime, end ClockTime) time.Time {
+	local := t.In(loc)
+	current := local.Hour()*60 + local.Minute()
+
+	var inside bool
+	if start.minutes() <= end.minutes() {
+		inside = current >= start.minutes() && current < end.minutes()
+	} else {
+		inside = current >= start.minutes() || current < end.minutes()
+	}
+	if !inside {
+		return t
+	}
+
+	return NextLocalTime(t, loc, end)
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("failed to load %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestParseClockTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    ClockTime
+		wantErr bool
+	}{
+		{"08:00", ClockTime{8, 0}, false},
+		{"23:59", ClockTime{23, 59}, false},
+		{"00:00", ClockTime{0, 0}, false},
+		{"24:00", ClockTime{}, true},
+		{"8:00", ClockTime{8, 0}, false},
+		{"08:60", ClockTime{}, true},
+		{"", ClockTime{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseClockTime(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseClockTime(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadLocation(t *testing.T) {
+	tests := []struct {
+		timezone string
+		want     string
+	}{
+		{"Europe/Berlin", "Europe/Berlin"},
+		{"", "UTC"},
+		{"Mars/Olympus_Mons", "UTC"},
+	}
+	for _, tt := range tests {
+		if got := LoadLocation(tt.timezone).String(); got != tt.want {
+			t.Errorf("LoadLocation(%q) = %s, want %s", tt.timezone, got, tt.want)
+		}
+	}
+}
+
+func TestNextLocalTime(t *testing.T) {
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	kolkata := mustLoadLocation(t, "Asia/Kolkata")
+
+	tests := []struct {
+		name string
+		now  time.Time
+		loc  *time.Location
+		at   ClockTime
+		want time.Time
+	}{
+		{"later today", time.Date(2026, time.June, 10, 6, 0, 0, 0, berlin), berlin, ClockTime{9, 0},
+			time.Date(2026, time.June, 10, 9, 0, 0, 0, berlin)},
+		{"already passed today", time.Date(2026, time.June, 10, 9, 1, 0, 0, berlin), berlin, ClockTime{9, 0},
+			time.Date(2026, time.June, 11, 9, 0, 0, 0, berlin)},
+		{"exactly now", time.Date(2026, time.June, 10, 9, 0, 0, 0, berlin), berlin, ClockTime{9, 0},
+			time.Date(2026, time.June, 10, 9, 0, 0, 0, berlin)},
+		// 20:00 UTC is already 01:30 the next day in Kolkata
+		{"local date ahead of UTC", time.Date(2026, time.June, 10, 20, 0, 0, 0, time.UTC), kolkata, ClockTime{9, 0},
+			time.Date(2026, time.June, 11, 9, 0, 0, 0, kolkata)},
+		{"across the end of the month", time.Date(2026, time.January, 31, 22, 0, 0, 0, time.UTC), time.UTC, ClockTime{8, 0},
+			time.Date(2026, time.February, 1, 8, 0, 0, 0, time.UTC)},
+		// Clocks go from 02:00 to 03:00 on 29 March 2026, 02:30 doesn't exist and becomes 03:30 summer time
+		{"inside the spring forward gap", time.Date(2026, time.March, 29, 0, 0, 0, 0, berlin), berlin, ClockTime{2, 30},
+			time.Date(2026, time.March, 29, 1, 30, 0, 0, time.UTC)},
+		{"on the day after the clocks change", time.Date(2026, time.March, 28, 10, 0, 0, 0, berlin), berlin, ClockTime{9, 0},
+			time.Date(2026, time.March, 29, 7, 0, 0, 0, time.UTC)},
+		// Clocks go from 03:00 back to 02:00 on 25 October 2026, 09:00 is still a single moment
+		{"on the day the clocks go back", time.Date(2026, time.October, 24, 12, 0, 0, 0, berlin), berlin, ClockTime{9, 0},
+			time.Date(2026, time.October, 25, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NextLocalTime(tt.now, tt.loc, tt.at); !got.Equal(tt.want) {
+				t.Errorf("NextLocalTime = %s, want %s", got, tt.want.In(tt.loc))
+			}
+		})
+	}
+}
+
+func TestDeferQuietHours(t *testing.T) {
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+	night := [2]ClockTime{{22, 0}, {8, 0}}
+	lunch := [2]ClockTime{{12, 0}, {13, 30}}
+	day := func(d int, hour int, minute int) time.Time {
+		return time.Date(2026, time.June, d, hour, minute, 0, 0, berlin)
+	}
+
+	tests := []struct {
+		name   string
+		t      time.Time
+		window [2]ClockTime
+		want   time.Time
+	}{
+		{"wrapping, before it starts", day(10, 21, 59), night, day(10, 21, 59)},
+		{"wrapping, at the start", day(10, 22, 0), night, day(11, 8, 0)},
+		{"wrapping, before midnight", day(10, 23, 30), night, day(11, 8, 0)},
+		{"wrapping, after midnight", day(11, 0, 15), night, day(11, 8, 0)},
+		{"wrapping, a minute before the end", day(11, 7, 59), night, day(11, 8, 0)},
+		{"wrapping, at the end", day(11, 8, 0), night, day(11, 8, 0)},
+		{"same day window, inside", day(10, 12, 45), lunch, day(10, 13, 30)},
+		{"same day window, at the end", day(10, 13, 30), lunch, day(10, 13, 30)},
+		{"same day window, outside", day(10, 23, 0), lunch, day(10, 23, 0)},
+		{"equal start and end is no window", day(10, 22, 0), [2]ClockTime{{22, 0}, {22, 0}}, day(10, 22, 0)},
+		// 01:30 winter time, the night ends at 08:00 summer time once the clocks went forward
+		{"across the spring forward", time.Date(2026, time.March, 29, 1, 30, 0, 0, berlin), night,
+			time.Date(2026, time.March, 29, 6, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DeferQuietHours(tt.t, berlin, tt.window[0], tt.window[1])
+			if !got.Equal(tt.want) {
+				t.Errorf("DeferQuietHours(%s) = %s, want %s", tt.t, got.In(berlin), tt.want.In(berlin))
+			}
+		})
+	}
+}
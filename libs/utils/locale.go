@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// NormalizeLocale lowercases a locale and uses - as the separator, so en_US, en-US and en-us are the same locale
+func NormalizeLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+}
+
+// IsValidLocale reports whether a normalized locale looks like a BCP 47 tag (en, de, pt-br, zh-hant-tw...)
+func IsValidLocale(locale string) bool {
+	return localePattern.MatchString(locale)
+}
+
+// LocaleFallbacks returns the locales to try for a user, most specific first:
+// the locale itself, its parent locales (de-at-x, de-at, de) and finally the default locale
+func LocaleFallbacks(locale string, defaultLocale string) []string {
+	var chain []string
+	add := func(l string) {
+		for _, existing := range chain {
+			if existing == l {
+				return
+			}
+		}
+		chain = append(chain, l)
+	}
+
+	for locale = NormalizeLocale(locale); locale != ""; {
+		add(locale)
+		cut := strings.LastIndex(locale, "-")
+		if cut < 0 {
+			break
+		}
+		locale = locale[:cut]
+	}
+	add(NormalizeLocale(defaultLocale))
+	return chain
+}
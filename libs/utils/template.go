@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Matches anything between {{ and }}, the name inside is checked separately so a typo is an error rather than left as is
+var placeholderPattern = regexp.MustCompile(`\{\{(.*?)\}\}`)
+
+var variableNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// TemplateVariables returns the variable names used in text, in order of appearance and without duplicates
+func TemplateVariables(text string) ([]string, error) {
+	var names []string
+	seen := map[string]bool{}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(text, -1) {
+		name := strings.TrimSpace(match[1])
+		if !variableNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("malformed placeholder %s", match[0])
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if strings.Contains(placeholderPattern.ReplaceAllString(text, ""), "{{") {
+		return nil, fmt.Errorf("unclosed placeholder in %q", text)
+	}
+	return names, nil
+}
+
+// RenderTemplate replaces every {{variable}} in text with its value. A variable without a value is an error,
+// so a message never goes out with a raw placeholder in it.
+func RenderTemplate(text string, values map[string]string) (string, error) {
+	names, err := TemplateVariables(text)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return "", fmt.Errorf("no value for {{%s}}", name)
+		}
+	}
+
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		return values[strings.TrimSpace(placeholder[2:len(placeholder)-2])]
+	}), nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"PingMeMaybe/libs/config"
 	"PingMeMaybe/libs/db"
 	"PingMeMaybe/processor/pkg/cron"
 	"PingMeMaybe/processor/server"
@@ -13,11 +14,17 @@ func main() {
 		panic("Failed to initialize database connection: " + err.Error())
 	}
 
+	asynqClient := config.GetAsynqClient()
+	defer asynqClient.Close()
+
 	dbService := db.NewDBService(dbConn)
-	crons := cron.GetCrons(dbService)
+	crons := cron.GetCrons(dbService, asynqClient)
 
 	// CRONS
-	go crons.StartMarkFailuresCron() // v1: every 10 seconds
+	go crons.StartMarkFailuresCron()       // v1: every 10 seconds
+	go crons.StartOutboxRelayCron()        // every second, moves outbox rows into asynq
+	go crons.StartExpiryReminderCron()     // daily by default, see EXPIRY_REMINDER_SCHEDULE
+	go crons.StartLifecycleSchedulerCron() // every 15 minutes by default, see LIFECYCLE_SCHEDULE
 
 	// Asynq listener
 	server.StartAsynqServer(dbConn)
@@ -0,0 +1,75 @@
+package channels
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"context"
+	"fmt"
+	"github.com/hibiken/asynq"
+)
+
+// Message is what a channel delivers, the notification content and who it is for
+type Message struct {
+	NotificationID int
+	Title          string
+	Description    string
+	Link           string
+	// Endpoint of the webhook channel, set per notification
+	WebhookURL string
+	// Nil for notifications that are not addressed to a user
+	Recipient *models.UserCohort
+}
+
+// Capabilities describes what a channel can deliver, so the processor can tell apart
+// a message the channel can never send from one that failed to send
+type Capabilities struct {
+	// The channel addresses a user (email address, phone, device) and can't send without a recipient
+	RequiresRecipient bool `json:"requires_recipient"`
+	SupportsHTML      bool `json:"supports_html"`
+	SupportsLinks     bool `json:"supports_links"`
+	// Longest body the channel accepts, 0 means no limit
+	MaxBodyLength int `json:"max_body_length"`
+}
+
+// Channel delivers notifications over one medium (email, SMS, push, webhooks...)
+type Channel interface {
+	// Name identifies the channel in delivery records and logs
+	Name() string
+	Capabilities() Capabilities
+	// Send delivers the message, an error makes asynq retry the task
+	Send(ctx context.Context, message Message) error
+}
+
+// Registry maps the channel ids stored on notifications to their implementations
+type Registry struct {
+	channels       map[int]Channel
+	defaultChannel Channel
+}
+
+// NewRegistry creates a registry that routes notifications without a channel id to defaultChannel
+func NewRegistry(defaultChannel Channel) *Registry {
+	return &Registry{
+		channels:       map[int]Channel{},
+		defaultChannel: defaultChannel,
+	}
+}
+
+func (r *Registry) Register(channelID int, channel Channel) {
+	r.channels[channelID] = channel
+}
+
+// Resolve returns the channel of a notification, channelID is the nullable models.Notification.ChannelID
+func (r *Registry) Resolve(channelID *int) (Channel, error) {
+	if channelID == nil {
+		return r.defaultChannel, nil
+	}
+	channel, ok := r.channels[*channelID]
+	if !ok {
+		return nil, fmt.Errorf("no channel registered for channel id %d", *channelID)
+	}
+	return channel, nil
+}
+
+// Permanent marks an error that retrying can't fix, asynq archives the task instead of retrying it
+func Permanent(err error) error {
+	return fmt.Errorf("%v: %w", err, asynq.SkipRetry)
+}
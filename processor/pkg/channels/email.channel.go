@@ -0,0 +1,188 @@
+package channels
+
+import (
+	"PingMeMaybe/libs/config"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var emailHTMLTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body>
+	<h2>{{.Title}}</h2>
+	<p>{{.Description}}</p>
+	{{if .Link}}<p><a href="{{.Link}}">{{.Link}}</a></p>{{end}}
+</body>
+</html>
+`))
+
+// EmailChannel sends notifications over SMTP as multipart text and HTML mails
+type EmailChannel struct {
+	config config.SMTPConfig
+}
+
+func NewEmailChannel(config config.SMTPConfig) Channel {
+	return &EmailChannel{config}
+}
+
+func (e *EmailChannel) Name() string {
+	return "email"
+}
+
+func (e *EmailChannel) Capabilities() Capabilities {
+	return Capabilities{
+		RequiresRecipient: true,
+		SupportsHTML:      true,
+		SupportsLinks:     true,
+	}
+}
+
+func (e *EmailChannel) Send(ctx context.Context, message Message) error {
+	if message.Recipient == nil || message.Recipient.Email == "" {
+		return Permanent(errors.New("recipient has no email address"))
+	}
+
+	from, err := mail.ParseAddress(e.config.From)
+	if err != nil {
+		return Permanent(fmt.Errorf("invalid sender address %q: %v", e.config.From, err))
+	}
+	to := &mail.Address{
+		Name:    strings.TrimSpace(message.Recipient.FirstName + " " + message.Recipient.LastName),
+		Address: message.Recipient.Email,
+	}
+
+	body, err := buildEmail(from, to, message)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	err = e.deliver(ctx, from.Address, to.Address, body)
+	// 5xx replies (unknown mailbox, rejected content...) won't succeed on a retry
+	var smtpErr *textproto.Error
+	if errors.As(err, &smtpErr) && smtpErr.Code >= 500 {
+		return Permanent(err)
+	}
+	return err
+}
+
+// deliver runs the SMTP conversation, bounded by the task's deadline. STARTTLS and auth are
+// only used when the server offers them and credentials are configured, so a local sink works as is.
+func (e *EmailChannel) deliver(ctx context.Context, from string, to string, body []byte) error {
+	addr := net.JoinHostPort(e.config.Host, strconv.Itoa(e.config.Port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, e.config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start smtp session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: e.config.Host}); err != nil {
+			return fmt.Errorf("failed to start tls: %w", err)
+		}
+	}
+	if e.config.Username != "" {
+		auth := smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth failed: %w", err)
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(body); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildEmail renders the message as a multipart/alternative mail with a plain text and an HTML part
+func buildEmail(from *mail.Address, to *mail.Address, message Message) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	text := message.Title + "\r\n\r\n" + message.Description
+	if message.Link != "" {
+		text += "\r\n\r\n" + message.Link
+	}
+	if err := writeEmailPart(parts, "text/plain; charset=UTF-8", []byte(text)); err != nil {
+		return nil, err
+	}
+
+	var html bytes.Buffer
+	if err := emailHTMLTemplate.Execute(&html, message); err != nil {
+		return nil, fmt.Errorf("failed to render email: %w", err)
+	}
+	if err := writeEmailPart(parts, "text/html; charset=UTF-8", html.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var mailBuf bytes.Buffer
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + to.String(),
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Title),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + parts.Boundary(),
+	}
+	mailBuf.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n")
+	mailBuf.Write(body.Bytes())
+
+	return mailBuf.Bytes(), nil
+}
+
+func writeEmailPart(parts *multipart.Writer, contentType string, content []byte) error {
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+	part, err := parts.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	writer := quotedprintable.NewWriter(part)
+	if _, err := writer.Write(content); err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,254 @@
+package channels
+
+import (
+	"PingMeMaybe/libs/config"
+	"PingMeMaybe/libs/db/models"
+	"bufio"
+	"context"
+	"errors"
+	"github.com/hibiken/asynq"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSink is an in-process SMTP server that accepts one mail per session and hands it to the test
+type smtpSink struct {
+	listener net.Listener
+	// Reply to RCPT TO, a test rejects the recipient with a 4xx or 5xx
+	rcptReply string
+	mails     chan sinkMail
+}
+
+type sinkMail struct {
+	from string
+	to   string
+	data []byte
+}
+
+func newSMTPSink(t *testing.T, rcptReply string) *smtpSink {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	sink := &smtpSink{listener: listener, rcptReply: rcptReply, mails: make(chan sinkMail, 1)}
+	t.Cleanup(func() { listener.Close() })
+	go sink.serve()
+	return sink
+}
+
+func (s *smtpSink) config() config.SMTPConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return config.SMTPConfig{
+		Host: addr.IP.String(),
+		Port: addr.Port,
+		From: "PingMeMaybe <no-reply@pingmemaybe.local>",
+	}
+}
+
+func (s *smtpSink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(conn)
+	}
+}
+
+func (s *smtpSink) session(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	var mail sinkMail
+
+	text.PrintfLine("220 sink ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 sink")
+		case "MAIL":
+			mail.from = line
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			mail.to = line
+			text.PrintfLine("%s", s.rcptReply)
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+			mail.data = data
+			s.mails <- mail
+			text.PrintfLine("250 OK")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func testMessage() Message {
+	return Message{
+		NotificationID: 7,
+		Title:          "Your plan is about to expire",
+		Description:    "Renew now to keep your premium features.",
+		Link:           "https://example.com/renew?a=1&b=2",
+		Recipient: &models.UserCohort{
+			UserID:    42,
+			Email:     "ada@example.com",
+			FirstName: "Ada",
+			LastName:  "Lovelace",
+		},
+	}
+}
+
+func TestEmailChannelSendsMultipartMail(t *testing.T) {
+	sink := newSMTPSink(t, "250 OK")
+	channel := NewEmailChannel(sink.config())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := channel.Send(ctx, testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	var received sinkMail
+	select {
+	case received = <-sink.mails:
+	case <-ctx.Done():
+		t.Fatal("the sink got no mail")
+	}
+
+	if received.from != "MAIL FROM:<no-reply@pingmemaybe.local>" {
+		t.Errorf("MAIL FROM = %q", received.from)
+	}
+	if received.to != "RCPT TO:<ada@example.com>" {
+		t.Errorf("RCPT TO = %q", received.to)
+	}
+
+	msg, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(string(received.data))))
+	if err != nil {
+		t.Fatalf("failed to parse mail: %v", err)
+	}
+
+	headers := []struct {
+		name string
+		want string
+	}{
+		{"From", `"PingMeMaybe" <no-reply@pingmemaybe.local>`},
+		{"To", `"Ada Lovelace" <ada@example.com>`},
+		{"Subject", "Your plan is about to expire"},
+		{"MIME-Version", "1.0"},
+	}
+	for _, header := range headers {
+		value := msg.Header.Get(header.name)
+		if header.name == "Subject" {
+			value, _ = new(mime.WordDecoder).DecodeHeader(value)
+		}
+		if value != header.want {
+			t.Errorf("%s = %q, want %q", header.name, value, header.want)
+		}
+	}
+	if _, err := msg.Header.Date(); err != nil {
+		t.Errorf("invalid Date header: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q (%v), want multipart/alternative", msg.Header.Get("Content-Type"), err)
+	}
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		if encoding := part.Header.Get("Content-Transfer-Encoding"); encoding != "quoted-printable" {
+			t.Errorf("Content-Transfer-Encoding = %q, want quoted-printable", encoding)
+		}
+		content, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatalf("failed to decode part: %v", err)
+		}
+		parts[part.Header.Get("Content-Type")] = string(content)
+	}
+
+	text, ok := parts["text/plain; charset=UTF-8"]
+	if !ok {
+		t.Fatalf("no text part, got %v", parts)
+	}
+	// The sink's dot reader turns the CRLF line endings into LF
+	wantText := "Your plan is about to expire\n\nRenew now to keep your premium features.\n\nhttps://example.com/renew?a=1&b=2"
+	if text != wantText {
+		t.Errorf("text part = %q, want %q", text, wantText)
+	}
+
+	html, ok := parts["text/html; charset=UTF-8"]
+	if !ok {
+		t.Fatalf("no html part, got %v", parts)
+	}
+	for _, want := range []string{
+		"<h2>Your plan is about to expire</h2>",
+		"<p>Renew now to keep your premium features.</p>",
+		`<a href="https://example.com/renew?a=1&amp;b=2">`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("html part has no %q:\n%s", want, html)
+		}
+	}
+}
+
+func TestEmailChannelErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		rcptReply     string
+		message       func() Message
+		wantPermanent bool
+	}{
+		{"rejected mailbox", "550 no such user", testMessage, true},
+		{"busy mailbox", "451 try again later", testMessage, false},
+		{"no email address", "250 OK", func() Message {
+			message := testMessage()
+			message.Recipient.Email = ""
+			return message
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newSMTPSink(t, tt.rcptReply)
+			channel := NewEmailChannel(sink.config())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err := channel.Send(ctx, tt.message())
+			if err == nil {
+				t.Fatal("Send succeeded, want an error")
+			}
+			if permanent := errors.Is(err, asynq.SkipRetry); permanent != tt.wantPermanent {
+				t.Errorf("permanent = %v, want %v (%v)", permanent, tt.wantPermanent, err)
+			}
+		})
+	}
+}
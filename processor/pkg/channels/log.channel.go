@@ -0,0 +1,33 @@
+package channels
+
+import (
+	"context"
+	"log"
+)
+
+// LogChannel only logs the notification. It is the default channel, and stands in
+// for channels that don't have a real sender yet.
+type LogChannel struct {
+	name string
+}
+
+func NewLogChannel(name string) Channel {
+	return &LogChannel{name}
+}
+
+func (l *LogChannel) Name() string {
+	return l.name
+}
+
+func (l *LogChannel) Capabilities() Capabilities {
+	return Capabilities{SupportsLinks: true}
+}
+
+func (l *LogChannel) Send(ctx context.Context, message Message) error {
+	if message.Recipient != nil {
+		log.Printf("🔔 [%s] Sending notification %d to user %d (%s): %s \n %s", l.name, message.NotificationID, message.Recipient.UserID, message.Recipient.Email, message.Title, message.Description)
+		return nil
+	}
+	log.Printf("🔔 [%s] Sending notification %d: %s \n %s", l.name, message.NotificationID, message.Title, message.Description)
+	return nil
+}
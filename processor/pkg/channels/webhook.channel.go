@@ -0,0 +1,181 @@
+package channels
+
+import (
+	"PingMeMaybe/libs/config"
+	"PingMeMaybe/libs/db/models"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	webhookSignatureHeader = "X-PingMeMaybe-Signature"
+	webhookTimestampHeader = "X-PingMeMaybe-Timestamp"
+)
+
+// errWebhookAddressBlocked is returned when a notification's webhook_url resolves to an internal address
+var errWebhookAddressBlocked = errors.New("webhook address is not public")
+
+// WebhookChannel POSTs notifications as JSON to the consumer's endpoint.
+// Every request is signed: the signature header is "sha256=" followed by the hex HMAC-SHA256
+// of "<timestamp>.<body>", keyed with the signing secret, and the timestamp header carries the unix time used.
+// A notification's own webhook_url has to be on the allowlist and resolve to a public address,
+// WEBHOOK_DEFAULT_URL is the operator's and may be internal. Redirects are not followed.
+type WebhookChannel struct {
+	config config.WebhookConfig
+	// Sends to WEBHOOK_DEFAULT_URL
+	client *http.Client
+	// Sends to the webhook_url of a notification, only dials public addresses
+	publicClient *http.Client
+}
+
+type webhookBody struct {
+	NotificationID int                `json:"notification_id"`
+	Title          string             `json:"title"`
+	Description    string             `json:"description"`
+	Link           string             `json:"link,omitempty"`
+	Recipient      *models.UserCohort `json:"recipient,omitempty"`
+	SentAt         time.Time          `json:"sent_at"`
+}
+
+func NewWebhookChannel(config config.WebhookConfig) Channel {
+	// The address is checked once resolved, so a host that resolves to an internal address is caught too
+	dialer := &net.Dialer{
+		Timeout: config.Timeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errWebhookAddressBlocked, host)
+			}
+			return nil
+		},
+	}
+	publicTransport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would dial on our behalf and skip the address check
+	publicTransport.Proxy = nil
+	publicTransport.DialContext = dialer.DialContext
+
+	noRedirects := func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &WebhookChannel{
+		config:       config,
+		client:       &http.Client{Timeout: config.Timeout, CheckRedirect: noRedirects},
+		publicClient: &http.Client{Timeout: config.Timeout, CheckRedirect: noRedirects, Transport: publicTransport},
+	}
+}
+
+// isPublicIP reports whether an address is reachable on the internet, rather than loopback, private,
+// link-local (cloud metadata endpoints live there) or shared address space
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	// 100.64.0.0/10, carrier-grade NAT
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return false
+	}
+	return true
+}
+
+func (w *WebhookChannel) Name() string {
+	return "webhook"
+}
+
+func (w *WebhookChannel) Capabilities() Capabilities {
+	return Capabilities{SupportsLinks: true}
+}
+
+func (w *WebhookChannel) Send(ctx context.Context, message Message) error {
+	if w.config.SigningSecret == "" {
+		return Permanent(errors.New("webhook channel not configured"))
+	}
+
+	url, client := message.WebhookURL, w.publicClient
+	if url == "" {
+		url, client = w.config.DefaultURL, w.client
+	} else if !w.config.AllowedHosts.Allows(url) {
+		return Permanent(fmt.Errorf("webhook url %s is not on WEBHOOK_ALLOWED_HOSTS", url))
+	}
+	if url == "" {
+		return Permanent(errors.New("no webhook url on the notification and no WEBHOOK_DEFAULT_URL configured"))
+	}
+
+	now := time.Now()
+	body, err := json.Marshal(webhookBody{
+		NotificationID: message.NotificationID,
+		Title:          message.Title,
+		Description:    message.Description,
+		Link:           message.Link,
+		Recipient:      message.Recipient,
+		SentAt:         now.UTC(),
+	})
+	if err != nil {
+		return Permanent(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(fmt.Errorf("invalid webhook request: %v", err))
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PingMeMaybe-Webhook/1.0")
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, "sha256="+w.sign(timestamp, body))
+
+	// Network errors and timeouts are transient, asynq retries them
+	resp, err := client.Do(req)
+	if errors.Is(err, errWebhookAddressBlocked) {
+		return Permanent(err)
+	}
+	if err != nil {
+		return fmt.Errorf("webhook request to %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+	// Read a bit of the reply for the error message, and so the connection can be reused
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("webhook %s responded %d: %s", url, resp.StatusCode, bytes.TrimSpace(reply))
+	if isRetryableStatus(resp.StatusCode) {
+		return err
+	}
+	return Permanent(err)
+}
+
+func (w *WebhookChannel) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.config.SigningSecret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isRetryableStatus tells apart responses worth retrying (timeouts, rate limits, server errors)
+// from ones that will fail the same way every time (bad request, unauthorized, gone...)
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	}
+	return status >= 500
+}
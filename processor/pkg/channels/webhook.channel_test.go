@@ -0,0 +1,109 @@
+package channels
+
+import (
+	"PingMeMaybe/libs/config"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/hibiken/asynq"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookAllowlist(t *testing.T) {
+	allowlist := config.WebhookAllowlist{"hooks.example.com", "*.example.org"}
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://hooks.example.com/notify", true},
+		{"https://HOOKS.example.com:8443/notify", true},
+		{"https://a.example.org/notify", true},
+		{"https://example.org/notify", false},
+		{"https://evil.com/?hooks.example.com", false},
+		{"https://hooks.example.com.evil.com/", false},
+		{"ftp://hooks.example.com/", false},
+		{"not a url", false},
+	}
+	for _, tt := range tests {
+		if got := allowlist.Allows(tt.url); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+	if (config.WebhookAllowlist{}).Allows("https://hooks.example.com/") {
+		t.Error("an empty allowlist allows a host")
+	}
+}
+
+func TestWebhookChannel(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "/notify", http.StatusFound)
+			return
+		}
+		received = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	webhookConfig := config.WebhookConfig{
+		Timeout: 5 * time.Second,
+		// httptest listens on 127.0.0.1, allowed by host but not by address
+		AllowedHosts: config.WebhookAllowlist{"127.0.0.1"},
+	}
+
+	tests := []struct {
+		name          string
+		secret        string
+		defaultURL    string
+		webhookURL    string
+		wantErr       bool
+		wantPermanent bool
+	}{
+		{"default url", "secret", server.URL + "/notify", "", false, false},
+		{"no signing secret", "", server.URL + "/notify", "", true, true},
+		{"host not allowed", "secret", server.URL + "/notify", "https://hooks.example.com/notify", true, true},
+		{"internal address", "secret", server.URL + "/notify", server.URL + "/notify", true, true},
+		{"redirect", "secret", server.URL + "/redirect", "", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received, body = nil, nil
+			webhookConfig.SigningSecret = tt.secret
+			webhookConfig.DefaultURL = tt.defaultURL
+			channel := NewWebhookChannel(webhookConfig)
+
+			err := channel.Send(context.Background(), Message{NotificationID: 7, Title: "Hello", WebhookURL: tt.webhookURL})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if permanent := errors.Is(err, asynq.SkipRetry); permanent != tt.wantPermanent {
+					t.Errorf("permanent = %v, want %v (%v)", permanent, tt.wantPermanent, err)
+				}
+				if received != nil {
+					t.Error("the webhook was delivered")
+				}
+				return
+			}
+
+			if received == nil {
+				t.Fatal("the webhook was not delivered")
+			}
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(received.Header.Get(webhookTimestampHeader) + "."))
+			mac.Write(body)
+			if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); received.Header.Get(webhookSignatureHeader) != want {
+				t.Errorf("signature = %q, want %q", received.Header.Get(webhookSignatureHeader), want)
+			}
+		})
+	}
+}
@@ -1,19 +1,30 @@
 package cron
 
 import (
+	"PingMeMaybe/libs/config"
 	"PingMeMaybe/libs/db"
+	"github.com/hibiken/asynq"
 )
 
 type Crons struct {
 	MarkFailuresCronInterface
+	OutboxRelayCronInterface
+	ExpiryReminderCronInterface
+	LifecycleSchedulerCronInterface
 }
 
 type CronsInterface interface {
 	MarkFailuresCronInterface
+	OutboxRelayCronInterface
+	ExpiryReminderCronInterface
+	LifecycleSchedulerCronInterface
 }
 
-func GetCrons(dbService *db.DBService) CronsInterface {
+func GetCrons(dbService *db.DBService, asynqClient *asynq.Client) CronsInterface {
 	return &Crons{
 		NewMarkFailuresCron(dbService),
+		NewOutboxRelayCron(dbService, asynqClient),
+		NewExpiryReminderCron(dbService, config.GetExpiryReminderConfig()),
+		NewLifecycleSchedulerCron(dbService, config.GetLifecycleSchedulerConfig(), config.GetDefaultLocale()),
 	}
 }
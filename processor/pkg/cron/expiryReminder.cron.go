@@ -0,0 +1,146 @@
+package cron
+
+import (
+	"PingMeMaybe/libs/config"
+	"PingMeMaybe/libs/db"
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/dto"
+	"PingMeMaybe/libs/messagePatterns"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"log"
+	"time"
+)
+
+type ExpiryReminderCron struct {
+	db     *db.DBService
+	config config.ExpiryReminderConfig
+}
+
+type ExpiryReminderCronInterface interface {
+	// StartExpiryReminderCron To remind PREMIUM_NEAR_EXPIRY users at each configured number of days before their subscription ends
+	StartExpiryReminderCron()
+}
+
+func NewExpiryReminderCron(db *db.DBService, config config.ExpiryReminderConfig) ExpiryReminderCronInterface {
+	return &ExpiryReminderCron{
+		db,
+		config,
+	}
+}
+
+func (e *ExpiryReminderCron) StartExpiryReminderCron() {
+	if len(e.config.Thresholds) == 0 {
+		log.Println("No expiry reminder thresholds configured, not starting the expiry reminder cron")
+		return
+	}
+
+	cronJob := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
+	_, err := cronJob.AddFunc(e.config.Schedule, func() {
+		e.sendReminders(context.Background())
+	})
+	if err != nil {
+		log.Fatal("Failed to start cron job:", err)
+		return
+	}
+	cronJob.Start()
+}
+
+func (e *ExpiryReminderCron) sendReminders(ctx context.Context) {
+	// Thresholds are sorted largest first
+	users, err := e.db.UserCohorts.GetUsersNearExpiry(ctx, e.config.Thresholds[0])
+	if err != nil {
+		log.Println("Could not fetch users near expiry:", err)
+		return
+	}
+
+	sent, alreadyReminded, failed := 0, 0, 0
+	for _, user := range users {
+		// The cohort includes subscriptions ending today, a reminder for those expires before it could be sent
+		if user.DaysUntilExpiry == nil || user.SubscriptionEnd == nil || *user.DaysUntilExpiry < 1 {
+			continue
+		}
+
+		reminderID, err := e.remind(ctx, user, e.currentThreshold(*user.DaysUntilExpiry))
+		switch {
+		case err != nil:
+			log.Printf("Could not remind user %d of their subscription expiry: %v", user.UserID, err)
+			failed++
+		case reminderID == 0:
+			alreadyReminded++
+		default:
+			sent++
+		}
+	}
+
+	log.Printf("Expiry reminders: %d sent, %d already reminded, %d failed", sent, alreadyReminded, failed)
+}
+
+// currentThreshold returns the smallest threshold the user is within. A user who was only picked up
+// after passing several thresholds, say at 5 days with thresholds of 30 and 7, gets the 7 day reminder alone.
+func (e *ExpiryReminderCron) currentThreshold(daysUntilExpiry int) int {
+	threshold := e.config.Thresholds[0]
+	for _, t := range e.config.Thresholds {
+		if daysUntilExpiry <= t {
+			threshold = t
+		}
+	}
+	return threshold
+}
+
+// remind saves the reminder for the threshold together with its notification, the outbox relay queues it.
+// It returns 0 when the user already got the reminder.
+func (e *ExpiryReminderCron) remind(ctx context.Context, user models.UserCohort, threshold int) (int, error) {
+	days := *user.DaysUntilExpiry
+	notif := dto.PostNotificationDTO{
+		Title:       "Your subscription is about to expire",
+		Description: fmt.Sprintf("Your %s plan expires in %d day(s), renew it to keep your premium features.", user.SubscriptionTier, days),
+		UserID:      &user.UserID,
+		Priority:    messagePatterns.PriorityNormal,
+		// A reminder that couldn't go out before the subscription ended is pointless
+		ExpiresAt: user.SubscriptionEnd,
+	}
+	if e.config.TemplateID != 0 {
+		notif.TemplateID = &e.config.TemplateID
+	}
+	if e.config.ChannelID != 0 {
+		notif.ChannelID = &e.config.ChannelID
+	}
+
+	// Derived from the reminder, so asynq also refuses a second task for it
+	taskID := fmt.Sprintf("expiry-reminder-%d-%d-%s", user.UserID, threshold, user.SubscriptionEnd.Format(time.DateOnly))
+	notificationPayload, err := json.Marshal(models.NotificationPayload{})
+	if err != nil {
+		return 0, err
+	}
+	notification := models.Notification{
+		Title:         notif.Title,
+		Description:   notif.Description,
+		Payload:       notificationPayload,
+		ChannelID:     notif.ChannelID,
+		Status:        models.NotificationStatusProcessing,
+		TransactionId: taskID,
+	}
+	message := models.OutboxMessage{
+		TaskID:   taskID,
+		TaskType: messagePatterns.DispatchNotification,
+		Queue:    messagePatterns.QueueForPriority(notif.Priority),
+		MaxRetry: 10,
+		Timeout:  3 * time.Minute,
+		Deadline: user.SubscriptionEnd,
+	}
+	reminder := models.ExpiryReminder{
+		UserID:          user.UserID,
+		ThresholdDays:   threshold,
+		SubscriptionEnd: *user.SubscriptionEnd,
+	}
+
+	return e.db.ExpiryReminders.CreateReminder(ctx, reminder, notification, message, func(notificationID int) ([]byte, error) {
+		return json.Marshal(dto.DispatchNotificationDTO{
+			PostNotificationDTO: notif,
+			NotificationID:      notificationID,
+		})
+	})
+}
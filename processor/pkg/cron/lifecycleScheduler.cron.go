@@ -0,0 +1,156 @@
+package cron
+
+import (
+	"PingMeMaybe/libs/config"
+	"PingMeMaybe/libs/db"
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/dto"
+	"PingMeMaybe/libs/messagePatterns"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"log"
+	"time"
+)
+
+// Users of a rule's cohort claimed per transaction
+const lifecyclePageSize = 1000
+
+type LifecycleSchedulerCron struct {
+	db            *db.DBService
+	config        config.LifecycleSchedulerConfig
+	defaultLocale string
+}
+
+type LifecycleSchedulerCronInterface interface {
+	// StartLifecycleSchedulerCron To evaluate the enabled lifecycle rules and queue their messages to the users outside the cooldown
+	StartLifecycleSchedulerCron()
+}
+
+func NewLifecycleSchedulerCron(db *db.DBService, config config.LifecycleSchedulerConfig, defaultLocale string) LifecycleSchedulerCronInterface {
+	return &LifecycleSchedulerCron{
+		db,
+		config,
+		defaultLocale,
+	}
+}
+
+func (l *LifecycleSchedulerCron) StartLifecycleSchedulerCron() {
+	cronJob := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
+	_, err := cronJob.AddFunc(l.config.Schedule, func() {
+		ctx := context.Background()
+		rules, err := l.db.LifecycleRules.ListRules(ctx, true)
+		if err != nil {
+			log.Println("Could not fetch lifecycle rules:", err)
+			return
+		}
+
+		for _, rule := range rules {
+			if err := l.runRule(ctx, rule); err != nil {
+				log.Printf("Lifecycle rule %d (%s) failed: %v", rule.ID, rule.Name, err)
+			}
+		}
+	})
+	if err != nil {
+		log.Fatal("Failed to start cron job:", err)
+		return
+	}
+	cronJob.Start()
+}
+
+// runRule streams the rule's cohort and queues the rule's template to every user outside its cooldown.
+// The deliveries of a run are recorded under one notification, created once there is someone to send to.
+func (l *LifecycleSchedulerCron) runRule(ctx context.Context, rule models.LifecycleRule) error {
+	notification, err := l.runNotification(ctx, rule)
+	if err != nil {
+		return err
+	}
+
+	notificationID, matched, sent := 0, 0, 0
+	for users, err := range l.db.UserCohorts.StreamCohortUsers(ctx, rule.CohortType, rule.Filters, lifecyclePageSize) {
+		if err != nil {
+			return err
+		}
+
+		userIDs := make([]int, len(users))
+		for i, user := range users {
+			userIDs[i] = user.UserID
+		}
+
+		var claimed int
+		notificationID, claimed, err = l.db.LifecycleRules.EnqueueSends(ctx, rule, notificationID, notification, userIDs, func(send models.LifecycleSend) (models.OutboxMessage, error) {
+			return l.sendMessage(rule, notification, send)
+		})
+		if err != nil {
+			return err
+		}
+		matched += len(users)
+		sent += claimed
+	}
+
+	// Like a broadcast, the run is done once every delivery is queued
+	if notificationID != 0 {
+		if err := l.db.Notifications.UpdateNotificationStatus(ctx, notificationID, models.NotificationStatusSuccess); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Lifecycle rule %d (%s): %d users matched, %d outside the cooldown queued", rule.ID, rule.Name, matched, sent)
+	return l.db.LifecycleRules.RecordRun(ctx, rule.ID, notificationID, matched, sent)
+}
+
+// runNotification is the notification a run of the rule is saved as, with the unrendered default-locale variant of its template
+func (l *LifecycleSchedulerCron) runNotification(ctx context.Context, rule models.LifecycleRule) (models.Notification, error) {
+	template, err := l.db.Templates.GetTemplateByID(ctx, rule.TemplateID)
+	if err != nil {
+		return models.Notification{}, fmt.Errorf("failed to fetch template %d: %w", rule.TemplateID, err)
+	}
+	variant := template.Variant(l.defaultLocale, l.defaultLocale)
+	if variant == nil && len(template.Variants) > 0 {
+		variant = &template.Variants[0]
+	}
+	if variant == nil {
+		return models.Notification{}, fmt.Errorf("template %d has no variants", rule.TemplateID)
+	}
+
+	payload, err := json.Marshal(models.NotificationPayload{Link: variant.Link})
+	if err != nil {
+		return models.Notification{}, err
+	}
+	return models.Notification{
+		Title:         variant.Title,
+		Description:   variant.Description,
+		Payload:       payload,
+		ChannelID:     rule.ChannelID,
+		Status:        models.NotificationStatusProcessing,
+		TransactionId: fmt.Sprintf("lifecycle-%d-run-%s", rule.ID, uuid.NewString()),
+	}, nil
+}
+
+func (l *LifecycleSchedulerCron) sendMessage(rule models.LifecycleRule, notification models.Notification, send models.LifecycleSend) (models.OutboxMessage, error) {
+	payload, err := json.Marshal(dto.DispatchNotificationDTO{
+		PostNotificationDTO: dto.PostNotificationDTO{
+			Title:       notification.Title,
+			Description: notification.Description,
+			TemplateID:  &rule.TemplateID,
+			ChannelID:   rule.ChannelID,
+			UserID:      &send.UserID,
+			Priority:    messagePatterns.PriorityBulk,
+		},
+		NotificationID: send.NotificationID,
+	})
+	if err != nil {
+		return models.OutboxMessage{}, err
+	}
+
+	return models.OutboxMessage{
+		TaskID:   fmt.Sprintf("lifecycle-send-%d", send.ID),
+		TaskType: messagePatterns.DispatchNotification,
+		Payload:  payload,
+		Queue:    messagePatterns.QueueForPriority(messagePatterns.PriorityBulk),
+		MaxRetry: 10,
+		Timeout:  3 * time.Minute,
+	}, nil
+}
@@ -42,14 +42,15 @@ func (m *MarkFailuresCron) StartMarkFailuresCron() {
 
 		for _, n := range notifications {
 			notification := n // capture range variable
-			if utils.IsOlderThanOneDay(notification.CreatedAt) {
+			// A scheduled notification only starts processing at its send_at, possibly days after it was created
+			if utils.IsOlderThanOneDay(*notification.ProcessingStartedAt) {
 				g.Go(func() error {
-					fmt.Printf("Marking notification %d \n", notification.TransactionId)
+					fmt.Printf("Marking notification %s \n", notification.TransactionId)
 					err := m.db.Notifications.MarkNotificationAsFailed(context.Background(), notification.TransactionId)
 					if err != nil {
-						log.Printf("Failed to update notification %d status: %v\n", notification.Title, err)
+						log.Printf("Failed to update notification %s status: %v\n", notification.Title, err)
 					} else {
-						fmt.Printf("Notification %d marked as failed successfully\n", notification.Title)
+						fmt.Printf("Notification %s marked as failed successfully\n", notification.Title)
 					}
 					return err
 				})
@@ -0,0 +1,84 @@
+package cron
+
+import (
+	"PingMeMaybe/libs/db"
+	"PingMeMaybe/libs/db/models"
+	"context"
+	"errors"
+	"github.com/hibiken/asynq"
+	"github.com/robfig/cron/v3"
+	"log"
+	"time"
+)
+
+// Outbox messages relayed per run
+const outboxRelayBatchSize = 500
+
+type OutboxRelayCron struct {
+	db    *db.DBService
+	asynq *asynq.Client
+}
+
+type OutboxRelayCronInterface interface {
+	// StartOutboxRelayCron To keep moving the tasks the gateway saved in the outbox into asynq
+	StartOutboxRelayCron()
+}
+
+func NewOutboxRelayCron(db *db.DBService, asynq *asynq.Client) OutboxRelayCronInterface {
+	return &OutboxRelayCron{
+		db,
+		asynq,
+	}
+}
+
+func (o *OutboxRelayCron) StartOutboxRelayCron() {
+	// A slow relay must not overlap with the next run, both would wait on the same locked rows
+	cronJob := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
+	_, err := cronJob.AddFunc("@every 1s", func() {
+		relayed, err := o.db.Outbox.RelayPending(context.Background(), outboxRelayBatchSize, o.relay)
+		if err != nil {
+			log.Println("Could not relay outbox messages:", err)
+			return
+		}
+		if relayed > 0 {
+			log.Printf("Relayed %d outbox messages", relayed)
+		}
+	})
+	if err != nil {
+		log.Fatal("Failed to start cron job:", err)
+		return
+	}
+	cronJob.Start()
+}
+
+func (o *OutboxRelayCron) relay(message models.OutboxMessage) error {
+	task := asynq.NewTask(message.TaskType, message.Payload)
+	opts := []asynq.Option{
+		asynq.TaskID(message.TaskID),
+		asynq.Queue(message.Queue),
+		asynq.MaxRetry(message.MaxRetry),
+		asynq.Timeout(message.Timeout),
+		// Completed tasks keep their id for a day, so a message relayed twice
+		// (enqueued, but the relay crashed before marking it sent) is not delivered twice
+		asynq.Retention(24 * time.Hour),
+	}
+	if message.ProcessAt != nil {
+		opts = append(opts, asynq.ProcessAt(*message.ProcessAt))
+	}
+	if message.Deadline != nil {
+		opts = append(opts, asynq.Deadline(*message.Deadline))
+	}
+	if message.UniqueTTL > 0 {
+		opts = append(opts, asynq.Unique(message.UniqueTTL))
+	}
+	_, err := o.asynq.Enqueue(task, opts...)
+
+	// The task is already in asynq from an earlier relay
+	if errors.Is(err, asynq.ErrTaskIDConflict) || errors.Is(err, asynq.ErrDuplicateTask) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("Failed to relay outbox message %d (task %s): %v", message.ID, message.TaskID, err)
+	}
+	return err
+}
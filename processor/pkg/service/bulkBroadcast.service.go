@@ -0,0 +1,260 @@
+package service
+
+import (
+	"PingMeMaybe/libs/db"
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/dto"
+	"PingMeMaybe/libs/messagePatterns"
+	"PingMeMaybe/processor/pkg/channels"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hibiken/asynq"
+	"log"
+	"time"
+)
+
+const (
+	// Width of the users.id range covered by one chunk task
+	broadcastChunkSize = 10000
+	// Number of cohort users fetched and fanned out per query, the chunk checkpoints after every page
+	broadcastPageSize = 1000
+	// Number of cohort users read and copied into the snapshot at a time
+	broadcastSnapshotBatchSize = 5000
+)
+
+type bulkBroadcastService struct {
+	asynq    *asynq.Client
+	db       *db.DBService
+	channels *channels.Registry
+}
+
+type IBulkBroadcastService interface {
+	// HandleInitiateBulkBroadcast snapshots the cohort of a broadcast, splits the snapshot into user id ranges and queues one ProcessBroadcastChunk task per range
+	HandleInitiateBulkBroadcast(ctx context.Context, task *asynq.Task) error
+	// HandleBroadcastChunk fans a chunk out into one DispatchNotification task per user, resuming from the chunk's checkpoint
+	HandleBroadcastChunk(ctx context.Context, task *asynq.Task) error
+}
+
+func NewBulkBroadcastService(asynq *asynq.Client, db *db.DBService, channels *channels.Registry) IBulkBroadcastService {
+	return &bulkBroadcastService{
+		asynq,
+		db,
+		channels,
+	}
+}
+
+func (b bulkBroadcastService) HandleInitiateBulkBroadcast(ctx context.Context, task *asynq.Task) error {
+	var p dto.BulkBroadcastTaskDTO
+	if err := json.Unmarshal(task.Payload(), &p); err != nil {
+		return fmt.Errorf("invalid broadcast payload: %v: %w", err, asynq.SkipRetry)
+	}
+
+	// A scheduled broadcast leaves SCHEDULED once its fan-out starts
+	if err := b.db.Notifications.UpdateNotificationStatus(ctx, p.NotificationID, models.NotificationStatusProcessing); err != nil {
+		return err
+	}
+
+	// The audience is frozen before anything is sent, a retry reuses the snapshot once an attempt completed it.
+	// Exclusions and the suppression list are applied as the snapshot is taken, so the chunks only see who is left.
+	snapshot, err := b.db.Snapshots.CreateSnapshot(ctx, models.BroadcastSnapshot{
+		NotificationID: p.NotificationID,
+		CohortType:     p.CohortType,
+		Filters:        p.Filters,
+		Exclusions:     p.Exclusions,
+	}, b.db.UserCohorts.StreamCohortUsers(ctx, p.CohortType, p.Filters, broadcastSnapshotBatchSize),
+		models.NewSnapshotFilter(p.Exclusions, b.db.Suppressions, b.db.UserCohorts, b.db.Deliveries))
+	if err != nil {
+		return err
+	}
+	if excluded := snapshot.Excluded.Total(); excluded > 0 {
+		log.Printf("📣 Broadcast %d excluded %d users: %d suppressed, %v in excluded cohorts, %d recently messaged",
+			p.NotificationID, excluded, snapshot.Excluded.SuppressionList, snapshot.Excluded.Cohorts, snapshot.Excluded.RecentlyMessaged)
+	}
+	if snapshot.UserCount == 0 {
+		log.Printf("📣 Broadcast %d has no users in cohort %s", p.NotificationID, p.CohortType)
+		return b.db.Notifications.UpdateNotificationStatus(ctx, p.NotificationID, models.NotificationStatusSuccess)
+	}
+
+	minID, maxID, err := b.db.Snapshots.GetSnapshotUserIDRange(ctx, p.NotificationID)
+	if err != nil {
+		return err
+	}
+
+	var chunks []models.BroadcastChunk
+	for start := minID; start <= maxID; start += broadcastChunkSize {
+		chunks = append(chunks, models.BroadcastChunk{
+			NotificationID: p.NotificationID,
+			StartUserID:    start,
+			EndUserID:      min(start+broadcastChunkSize-1, maxID),
+		})
+	}
+	if err := b.db.BroadcastChunks.CreateChunks(ctx, chunks); err != nil {
+		return err
+	}
+
+	// Re-read the chunks, if this task is a retry some of them are already done
+	savedChunks, err := b.db.BroadcastChunks.GetChunksByNotification(ctx, p.NotificationID)
+	if err != nil {
+		return err
+	}
+
+	queued := 0
+	for _, chunk := range savedChunks {
+		if chunk.Status == models.BroadcastChunkStatusCompleted {
+			continue
+		}
+		if err := b.queueChunk(p, chunk); err != nil {
+			return err
+		}
+		queued++
+	}
+
+	// Every chunk finished in an earlier attempt, no chunk handler is left to complete the broadcast
+	if queued == 0 {
+		log.Printf("📣 Broadcast %d already fanned out to cohort %s", p.NotificationID, p.CohortType)
+		return b.db.Notifications.UpdateNotificationStatus(ctx, p.NotificationID, models.NotificationStatusSuccess)
+	}
+
+	log.Printf("📣 Broadcast %d split into %d chunks, %d queued (%d users %d-%d, cohort %s)", p.NotificationID, len(savedChunks), queued, snapshot.UserCount, minID, maxID, p.CohortType)
+	return nil
+}
+
+func (b bulkBroadcastService) HandleBroadcastChunk(ctx context.Context, task *asynq.Task) error {
+	var p dto.BroadcastChunkTaskDTO
+	if err := json.Unmarshal(task.Payload(), &p); err != nil {
+		return fmt.Errorf("invalid broadcast chunk payload: %v: %w", err, asynq.SkipRetry)
+	}
+
+	chunk, err := b.db.BroadcastChunks.GetChunkByID(ctx, p.ChunkID)
+	if err != nil {
+		return err
+	}
+	if chunk.Status == models.BroadcastChunkStatusCompleted {
+		return nil
+	}
+
+	channel, err := b.channels.Resolve(p.Notification.ChannelID)
+	if err != nil {
+		return channels.Permanent(err)
+	}
+
+	after := chunk.ResumeAfterUserID()
+	for {
+		users, err := b.db.Snapshots.GetSnapshotUsersInIDRange(ctx, p.NotificationID, p.CohortType, after, chunk.EndUserID, broadcastPageSize)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		// Pending deliveries are recorded before the tasks are queued, so every dispatched user has a row to report on
+		deliveries := make([]models.Delivery, 0, len(users))
+		for _, user := range users {
+			deliveries = append(deliveries, models.Delivery{
+				NotificationID: p.NotificationID,
+				UserID:         user.UserID,
+				Channel:        channel.Name(),
+			})
+		}
+		if err := b.db.Deliveries.CreateDeliveries(ctx, deliveries); err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			if err := b.dispatch(p.BulkBroadcastTaskDTO, user); err != nil {
+				return err
+			}
+		}
+
+		after = users[len(users)-1].UserID
+		if err := b.db.BroadcastChunks.SaveCheckpoint(ctx, chunk.ID, after, len(users)); err != nil {
+			return err
+		}
+
+		if len(users) < broadcastPageSize {
+			break
+		}
+	}
+
+	if err := b.db.BroadcastChunks.MarkChunkCompleted(ctx, chunk.ID); err != nil {
+		return err
+	}
+
+	// The last chunk to finish completes the broadcast
+	remaining, err := b.db.BroadcastChunks.CountIncompleteChunks(ctx, p.NotificationID)
+	if err != nil {
+		return err
+	}
+	if remaining == 0 {
+		log.Printf("📣 Broadcast %d fanned out to cohort %s", p.NotificationID, p.CohortType)
+		return b.db.Notifications.UpdateNotificationStatus(ctx, p.NotificationID, models.NotificationStatusSuccess)
+	}
+
+	return nil
+}
+
+func (b bulkBroadcastService) queueChunk(p dto.BulkBroadcastTaskDTO, chunk models.BroadcastChunk) error {
+	payload, err := json.Marshal(dto.BroadcastChunkTaskDTO{
+		ChunkID:              chunk.ID,
+		BulkBroadcastTaskDTO: p,
+	})
+	if err != nil {
+		return err
+	}
+
+	// A chunk that is still queued or running from a previous attempt keeps its task id, so it is not queued twice
+	task := asynq.NewTask(messagePatterns.ProcessBroadcastChunk, payload)
+	_, err = b.asynq.Enqueue(task,
+		asynq.TaskID(fmt.Sprintf("broadcast-%d-chunk-%d", p.NotificationID, chunk.ID)),
+		// Chunks are bulk work no matter the broadcast's priority
+		asynq.Queue(messagePatterns.QueueLow),
+		asynq.MaxRetry(10),
+		asynq.Timeout(10*time.Minute))
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("failed to queue chunk %d of broadcast %d: %w", chunk.ID, p.NotificationID, err)
+	}
+	return nil
+}
+
+// dispatch enqueues the notification for a single recipient. The task id is derived from the broadcast and the user,
+// and kept for a day after the task completes, so a chunk resumed from before its last checkpoint doesn't queue
+// a user twice. A user dispatched again after that is caught by the delivery check of the dispatch handler.
+func (b bulkBroadcastService) dispatch(p dto.BulkBroadcastTaskDTO, user models.UserCohort) error {
+	recipient := user
+	payload, err := json.Marshal(dto.DispatchNotificationDTO{
+		PostNotificationDTO: p.Notification,
+		NotificationID:      p.NotificationID,
+		Recipient:           &recipient,
+	})
+	if err != nil {
+		return err
+	}
+
+	task := asynq.NewTask(messagePatterns.DispatchNotification, payload)
+	opts := []asynq.Option{
+		asynq.TaskID(fmt.Sprintf("broadcast-%d-user-%d", p.NotificationID, user.UserID)),
+		asynq.Queue(messagePatterns.QueueForPriority(p.Notification.Priority)),
+		asynq.MaxRetry(10),
+		asynq.Timeout(3 * time.Minute),
+		asynq.Retention(24 * time.Hour),
+	}
+	// Expired broadcast notifications are dropped instead of delivered late
+	if p.Notification.ExpiresAt != nil {
+		opts = append(opts, asynq.Deadline(*p.Notification.ExpiresAt))
+	}
+	// Local delivery holds the task back until the right time in the recipient's timezone
+	if p.LocalDelivery != nil {
+		now := time.Now()
+		if deliverAt := p.LocalDelivery.DeliveryTime(now, user.Timezone); deliverAt.After(now) {
+			opts = append(opts, asynq.ProcessAt(deliverAt))
+		}
+	}
+	_, err = b.asynq.Enqueue(task, opts...)
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("failed to dispatch broadcast %d to user %d: %w", p.NotificationID, user.UserID, err)
+	}
+	return nil
+}
@@ -3,30 +3,43 @@ package service
 import (
 	"PingMeMaybe/libs/db/models"
 	"PingMeMaybe/libs/dto"
+	"PingMeMaybe/processor/pkg/channels"
+	"PingMeMaybe/processor/pkg/templates"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hibiken/asynq"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"log"
+	"time"
 )
 
 type notificationProcessorService struct {
-	db *pgxpool.Pool
+	db          *pgxpool.Pool
+	deliveries  models.IDeliveryRepository
+	userCohorts models.IUserCohortRepository
+	channels    *channels.Registry
+	renderer    *templates.Renderer
 }
 
 type INotificationProcessorService interface {
 	HandleNotificationQueueItems(ctx context.Context, task *asynq.Task) error
 }
 
-func NewNotificationProcessorService(db *pgxpool.Pool) INotificationProcessorService {
+func NewNotificationProcessorService(db *pgxpool.Pool, deliveries models.IDeliveryRepository, userCohorts models.IUserCohortRepository, channels *channels.Registry, renderer *templates.Renderer) INotificationProcessorService {
 	return &notificationProcessorService{
 		db,
+		deliveries,
+		userCohorts,
+		channels,
+		renderer,
 	}
 }
 
 func (n notificationProcessorService) HandleNotificationQueueItems(ctx context.Context, task *asynq.Task) error {
-	var p dto.PostNotificationDTO
+	var p dto.DispatchNotificationDTO
 	query := `UPDATE notifications SET status = $1 WHERE transaction_id = $2`
 
 	if err := json.Unmarshal(task.Payload(), &p); err != nil {
@@ -34,13 +47,174 @@ func (n notificationProcessorService) HandleNotificationQueueItems(ctx context.C
 		return err
 	}
 
-	// If the payload is successfully extracted, then mark notification as successful (for now)
-	_, err := n.db.Exec(ctx, query, models.NotificationStatusSuccess, task.ResultWriter().TaskID())
+	channel, err := n.channels.Resolve(p.ChannelID)
+	if err != nil {
+		return n.recordOutcome(ctx, task, p, models.DefaultDeliveryChannel, channels.Permanent(err))
+	}
+
+	if p.ExpiresAt != nil && time.Now().After(*p.ExpiresAt) {
+		return n.recordOutcome(ctx, task, p, channel.Name(), channels.Permanent(fmt.Errorf("notification expired at %s", p.ExpiresAt.Format(time.RFC3339))))
+	}
+
+	// Transactional notifications only carry the user id, broadcasts already come with the recipient
+	if p.Recipient == nil && p.UserID != nil {
+		p.Recipient, err = n.userCohorts.GetUserByID(ctx, *p.UserID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return n.recordOutcome(ctx, task, p, channel.Name(), channels.Permanent(fmt.Errorf("user %d does not exist", *p.UserID)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// A broadcast task queued twice for the same user (a chunk resumed after its task id expired) sends once
+	if p.Recipient != nil && p.NotificationID != 0 {
+		delivered, err := n.alreadyDelivered(ctx, p)
+		if err != nil {
+			return err
+		}
+		if delivered {
+			log.Printf("Notification %d was already delivered to user %d, skipping", p.NotificationID, p.Recipient.UserID)
+			return nil
+		}
+	}
+
+	if p.Recipient != nil && !p.Recipient.NotificationPrefs.Allows(channel.Name()) {
+		fallback := n.fallbackChannel(p)
+		if fallback == nil {
+			return n.recordSkipped(ctx, task, p, channel.Name())
+		}
+
+		// Keep a record that the requested channel was skipped, the attempt is recorded on the fallback
+		if err := n.recordSkipped(ctx, task, p, channel.Name()); err != nil {
+			return err
+		}
+		log.Printf("User %d opted out of %s, rerouting notification %d to %s", p.Recipient.UserID, channel.Name(), p.NotificationID, fallback.Name())
+		channel = fallback
+	}
+
+	if channel.Capabilities().RequiresRecipient && p.Recipient == nil {
+		return n.recordOutcome(ctx, task, p, channel.Name(), channels.Permanent(fmt.Errorf("channel %s needs a recipient", channel.Name())))
+	}
+
+	// Templated notifications are personalised per recipient, a template that doesn't render fails the delivery
+	// rather than sending raw placeholders
+	if p.TemplateID != nil {
+		content, err := n.renderer.Render(ctx, *p.TemplateID, p.Recipient)
+		var renderErr *templates.RenderError
+		if errors.As(err, &renderErr) {
+			return n.recordOutcome(ctx, task, p, channel.Name(), channels.Permanent(err))
+		}
+		if err != nil {
+			return err
+		}
+		p.Title, p.Description, p.Link = content.Title, content.Description, content.Link
+	}
+
+	err = channel.Send(ctx, channels.Message{
+		NotificationID: p.NotificationID,
+		Title:          p.Title,
+		Description:    p.Description,
+		Link:           p.Link,
+		WebhookURL:     p.WebhookURL,
+		Recipient:      p.Recipient,
+	})
+	return n.recordOutcome(ctx, task, p, channel.Name(), err)
+}
+
+// alreadyDelivered reports whether the notification was already sent to the recipient on any channel
+func (n notificationProcessorService) alreadyDelivered(ctx context.Context, p dto.DispatchNotificationDTO) (bool, error) {
+	deliveries, err := n.deliveries.GetUserDeliveries(ctx, p.NotificationID, p.Recipient.UserID)
+	if err != nil {
+		return false, err
+	}
+	for _, delivery := range deliveries {
+		if delivery.Status == models.DeliveryStatusSuccess {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fallbackChannel returns the first of the notification's fallback channels the recipient accepts, nil if there is none
+func (n notificationProcessorService) fallbackChannel(p dto.DispatchNotificationDTO) channels.Channel {
+	for _, channelID := range p.FallbackChannelIDs {
+		channel, err := n.channels.Resolve(&channelID)
+		if err != nil {
+			log.Printf("Ignoring fallback of notification %d: %v", p.NotificationID, err)
+			continue
+		}
+		if p.Recipient.NotificationPrefs.Allows(channel.Name()) {
+			return channel
+		}
+	}
+	return nil
+}
+
+// recordSkipped records that the recipient opted out of a channel. Nothing failed, so asynq sees a success.
+func (n notificationProcessorService) recordSkipped(ctx context.Context, task *asynq.Task, p dto.DispatchNotificationDTO, channelName string) error {
+	log.Printf("User %d opted out of %s, skipping notification %d", p.Recipient.UserID, channelName, p.NotificationID)
+
+	if p.NotificationID != 0 {
+		err := n.deliveries.RecordAttempt(ctx, p.NotificationID, p.Recipient.UserID, channelName, models.DeliveryStatusSkippedPreference, nil)
+		if err != nil {
+			fmt.Println("Error recording delivery:", err)
+			return err
+		}
+	}
+
+	query := `UPDATE notifications SET status = $1 WHERE transaction_id = $2`
+	_, err := n.db.Exec(ctx, query, models.NotificationStatusSkipped, task.ResultWriter().TaskID())
 	if err != nil {
 		fmt.Println("Error updating notification status:", err)
 		return err
 	}
-	log.Printf("🔔 Sending notification to user %d: %s \n %s", p.Id, p.Title, p.Description)
+	return nil
+}
+
+// recordOutcome stores the result of a send attempt and returns the error asynq should see.
+// A failure is final when it is permanent (wrapped with asynq.SkipRetry) or the task is out of retries,
+// until then the delivery is only marked as retrying.
+func (n notificationProcessorService) recordOutcome(ctx context.Context, task *asynq.Task, p dto.DispatchNotificationDTO, channelName string, sendErr error) error {
+	final := sendErr == nil || errors.Is(sendErr, asynq.SkipRetry) || isLastAttempt(ctx)
+
+	notificationStatus := models.NotificationStatusSuccess
+	deliveryStatus := models.DeliveryStatusSuccess
+	if sendErr != nil {
+		log.Printf("Failed to send notification %d on %s: %v", p.NotificationID, channelName, sendErr)
+		notificationStatus = models.NotificationStatusFailed
+		deliveryStatus = models.DeliveryStatusRetrying
+		if final {
+			deliveryStatus = models.DeliveryStatusFailed
+		}
+	}
+
+	if p.Recipient != nil && p.NotificationID != 0 {
+		err := n.deliveries.RecordAttempt(ctx, p.NotificationID, p.Recipient.UserID, channelName, deliveryStatus, sendErr)
+		if err != nil {
+			fmt.Println("Error recording delivery:", err)
+			return err
+		}
+	}
 
-	return nil // returning nil means success
+	// Broadcast fan-out tasks don't have a notification of their own, this only matches transactional notifications
+	if final {
+		query := `UPDATE notifications SET status = $1 WHERE transaction_id = $2`
+		_, err := n.db.Exec(ctx, query, notificationStatus, task.ResultWriter().TaskID())
+		if err != nil {
+			fmt.Println("Error updating notification status:", err)
+			return err
+		}
+	}
+
+	return sendErr // returning nil means success
+}
+
+func isLastAttempt(ctx context.Context) bool {
+	retried, ok := asynq.GetRetryCount(ctx)
+	if !ok {
+		return false
+	}
+	maxRetry, ok := asynq.GetMaxRetry(ctx)
+	return ok && retried >= maxRetry
 }
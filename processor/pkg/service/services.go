@@ -1,19 +1,38 @@
 package service
 
 import (
+	"PingMeMaybe/libs/config"
+	dbLib "PingMeMaybe/libs/db"
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/processor/pkg/channels"
+	"PingMeMaybe/processor/pkg/templates"
+	"github.com/hibiken/asynq"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type ProcessorServices struct {
 	INotificationProcessorService
+	IBulkBroadcastService
 }
 
 type IProcessorServices interface {
 	INotificationProcessorService
+	IBulkBroadcastService
 }
 
-func NewProcessorServices(db *pgxpool.Pool) IProcessorServices {
+func NewProcessorServices(db *pgxpool.Pool, asynqClient *asynq.Client) IProcessorServices {
+	dbService := dbLib.NewDBService(db)
+
+	// Delivery channels, keyed by the channel id of the notification
+	registry := channels.NewRegistry(channels.NewLogChannel(models.DefaultDeliveryChannel))
+	// Stand-ins that only log, until the real senders are plugged in
+	registry.Register(models.ChannelPush, channels.NewLogChannel("push"))
+	registry.Register(models.ChannelEmail, channels.NewEmailChannel(config.GetSMTPConfig()))
+	registry.Register(models.ChannelSMS, channels.NewLogChannel("sms"))
+	registry.Register(models.ChannelWebhook, channels.NewWebhookChannel(config.GetWebhookConfig()))
+
 	return &ProcessorServices{
-		INotificationProcessorService: NewNotificationProcessorService(db),
+		INotificationProcessorService: NewNotificationProcessorService(db, dbService.Deliveries, dbService.UserCohorts, registry, templates.NewRenderer(dbService.Templates, config.GetDefaultLocale())),
+		IBulkBroadcastService:         NewBulkBroadcastService(asynqClient, dbService, registry),
 	}
 }
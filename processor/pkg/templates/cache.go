@@ -0,0 +1,79 @@
+package templates
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// How long a template is rendered from memory, an edit reaches queued notifications after at most this long
+	templateCacheTTL = 30 * time.Second
+	// Most templates kept, the least recently used one is dropped first
+	templateCacheSize = 256
+)
+
+// templateCache is an LRU of recently read templates. A broadcast renders the same template for every
+// recipient, without it every recipient would read the template and its variants from the database.
+type templateCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	now     func() time.Time
+	entries map[int]*list.Element
+	// Most recently used first
+	order *list.List
+}
+
+type cachedTemplate struct {
+	template *models.Template
+	readAt   time.Time
+}
+
+func newTemplateCache(ttl time.Duration, size int) *templateCache {
+	return &templateCache{
+		ttl:     ttl,
+		size:    size,
+		now:     time.Now,
+		entries: map[int]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// get returns the cached template, nil if it isn't cached or is older than the TTL
+func (c *templateCache) get(id int) *models.Template {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return nil
+	}
+	cached := element.Value.(*cachedTemplate)
+	if c.now().Sub(cached.readAt) >= c.ttl {
+		c.order.Remove(element)
+		delete(c.entries, id)
+		return nil
+	}
+	c.order.MoveToFront(element)
+	return cached.template
+}
+
+func (c *templateCache) put(template *models.Template) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := &cachedTemplate{template: template, readAt: c.now()}
+	if element, ok := c.entries[template.ID]; ok {
+		element.Value = cached
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[template.ID] = c.order.PushFront(cached)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedTemplate).template.ID)
+	}
+}
@@ -0,0 +1,99 @@
+package templates
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"PingMeMaybe/libs/utils"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+)
+
+// Content is a template rendered for one recipient
+type Content struct {
+	Title       string
+	Description string
+	Link        string
+}
+
+// RenderError means the template can't be rendered for the recipient, retrying won't change that
+type RenderError struct {
+	TemplateID int
+	Err        error
+}
+
+func (e *RenderError) Error() string {
+	return fmt.Sprintf("could not render template %d: %v", e.TemplateID, e.Err)
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+type Renderer struct {
+	templates     models.ITemplateRepository
+	defaultLocale string
+	cache         *templateCache
+}
+
+func NewRenderer(templates models.ITemplateRepository, defaultLocale string) *Renderer {
+	return &Renderer{templates: templates, defaultLocale: defaultLocale, cache: newTemplateCache(templateCacheTTL, templateCacheSize)}
+}
+
+// Render picks the variant for the recipient's locale, falling back to its language and then the default locale,
+// and fills in its variables from the recipient. Templates are cached for templateCacheTTL, so an edit applies to
+// notifications that are already queued once that is up. A nil recipient gets the default locale and only renders templates without variables.
+func (r *Renderer) Render(ctx context.Context, templateID int, recipient *models.UserCohort) (Content, error) {
+	template, err := r.template(ctx, templateID)
+	if err != nil {
+		return Content{}, err
+	}
+
+	values := map[string]string{}
+	locale := r.defaultLocale
+	if recipient != nil {
+		values = recipient.TemplateVariables()
+		locale = recipient.Locale
+	}
+
+	variant := template.Variant(locale, r.defaultLocale)
+	if variant == nil {
+		return Content{}, &RenderError{TemplateID: templateID, Err: fmt.Errorf("no variant for locale %s, tried %v", locale, utils.LocaleFallbacks(locale, r.defaultLocale))}
+	}
+
+	var content Content
+	fields := []struct {
+		name   string
+		text   string
+		target *string
+	}{
+		{"title", variant.Title, &content.Title},
+		{"description", variant.Description, &content.Description},
+		{"link", variant.Link, &content.Link},
+	}
+	for _, field := range fields {
+		rendered, err := utils.RenderTemplate(field.text, values)
+		if err != nil {
+			return Content{}, &RenderError{TemplateID: templateID, Err: fmt.Errorf("%s variant, %s: %w", variant.Locale, field.name, err)}
+		}
+		*field.target = rendered
+	}
+	return content, nil
+}
+
+// template reads a template through the cache, a missing template is not cached
+func (r *Renderer) template(ctx context.Context, templateID int) (*models.Template, error) {
+	if template := r.cache.get(templateID); template != nil {
+		return template, nil
+	}
+
+	template, err := r.templates.GetTemplateByID(ctx, templateID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, &RenderError{TemplateID: templateID, Err: errors.New("template does not exist")}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch template %d: %w", templateID, err)
+	}
+	r.cache.put(template)
+	return template, nil
+}
@@ -0,0 +1,84 @@
+package templates
+
+import (
+	"PingMeMaybe/libs/db/models"
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"testing"
+	"time"
+)
+
+// countingTemplateRepo serves templates from memory and counts the reads
+type countingTemplateRepo struct {
+	models.ITemplateRepository
+	templates map[int]*models.Template
+	reads     int
+}
+
+func (r *countingTemplateRepo) GetTemplateByID(ctx context.Context, id int) (*models.Template, error) {
+	r.reads++
+	template, ok := r.templates[id]
+	if !ok {
+		return nil, pgx.ErrNoRows
+	}
+	return template, nil
+}
+
+func TestRendererCachesTemplates(t *testing.T) {
+	repo := &countingTemplateRepo{templates: map[int]*models.Template{
+		1: {ID: 1, Variants: []models.TemplateVariant{{Locale: "en", Title: "Hi {{first_name}}"}}},
+		2: {ID: 2, Variants: []models.TemplateVariant{{Locale: "en", Title: "Two"}}},
+		3: {ID: 3, Variants: []models.TemplateVariant{{Locale: "en", Title: "Three"}}},
+	}}
+	renderer := NewRenderer(repo, "en")
+	now := time.Now()
+	renderer.cache = newTemplateCache(time.Minute, 2)
+	renderer.cache.now = func() time.Time { return now }
+
+	render := func(templateID int) {
+		t.Helper()
+		if _, err := renderer.Render(context.Background(), templateID, &models.UserCohort{FirstName: "Ada", Locale: "en"}); err != nil {
+			t.Fatalf("Render(%d): %v", templateID, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		step      func()
+		wantReads int
+	}{
+		{"first render reads the template", func() { render(1) }, 1},
+		{"every other recipient is served from the cache", func() {
+			for range 1000 {
+				render(1)
+			}
+		}, 1},
+		{"another template is read", func() { render(2) }, 2},
+		{"a third template evicts the least recently used", func() { render(3); render(2) }, 3},
+		{"the evicted template is read again", func() { render(1) }, 4},
+		{"an expired template is read again", func() { now = now.Add(time.Minute); render(1) }, 5},
+	}
+	for _, tt := range tests {
+		tt.step()
+		if repo.reads != tt.wantReads {
+			t.Fatalf("%s: %d reads, want %d", tt.name, repo.reads, tt.wantReads)
+		}
+	}
+}
+
+func TestRendererDoesNotCacheMissingTemplates(t *testing.T) {
+	repo := &countingTemplateRepo{templates: map[int]*models.Template{}}
+	renderer := NewRenderer(repo, "en")
+
+	for range 2 {
+		_, err := renderer.Render(context.Background(), 9, nil)
+		var renderErr *RenderError
+		if !errors.As(err, &renderErr) {
+			t.Fatalf("Render error = %v, want a RenderError", err)
+		}
+	}
+	if repo.reads != 2 {
+		t.Errorf("%d reads, want 2", repo.reads)
+	}
+}
@@ -12,7 +12,10 @@ import (
 
 func StartAsynqServer(dbConn *pgxpool.Pool) {
 	// This is a background processor, wont be exposed by HTTP
-	services := service.NewProcessorServices(dbConn)
+	// The processor also produces tasks, the broadcast fan-out enqueues one task per recipient
+	asynqClient := config.GetAsynqClient()
+	defer asynqClient.Close()
+	services := service.NewProcessorServices(dbConn, asynqClient)
 
 	srv := asynq.NewServer(
 		asynq.RedisClientOpt{
@@ -20,20 +23,14 @@ func StartAsynqServer(dbConn *pgxpool.Pool) {
 			Username: config.GetConfig().GetString("REDIS_USERNAME"),
 			Password: config.GetConfig().GetString("REDIS_PASSWORD"),
 		},
-		asynq.Config{
-			Concurrency: 10,
-			// Priorities
-			Queues: map[string]int{
-				"critical": 6,
-				"default":  3,
-				"low":      1,
-			},
-		},
+		config.GetAsynqServerConfig(),
 	)
 
 	mux := asynq.NewServeMux()
 	// Register handlers with msg patterns
 	mux.HandleFunc(messagePatterns.DispatchNotification, services.HandleNotificationQueueItems)
+	mux.HandleFunc(messagePatterns.InitiateBulkBroadcast, services.HandleInitiateBulkBroadcast)
+	mux.HandleFunc(messagePatterns.ProcessBroadcastChunk, services.HandleBroadcastChunk)
 
 	if err := srv.Run(mux); err != nil {
 		log.Fatalf("could not run server: %v", err)
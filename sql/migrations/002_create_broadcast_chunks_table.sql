@@ -0,0 +1,24 @@
+-- Migration to create the broadcast chunks table
+-- A bulk broadcast is split into chunks over users.id ranges, each chunk is fanned out by its own task
+-- and checkpoints the last user id it dispatched so a retried task resumes instead of starting over
+
+CREATE TABLE IF NOT EXISTS broadcast_chunks (
+    id SERIAL PRIMARY KEY,
+    notification_id INTEGER NOT NULL REFERENCES notifications(id),
+
+    -- users.id range covered by the chunk, both ends inclusive
+    start_user_id INTEGER NOT NULL,
+    end_user_id INTEGER NOT NULL,
+
+    -- progress
+    checkpoint_user_id INTEGER,
+    dispatched_count INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) CHECK (status IN ('PENDING', 'PROCESSING', 'COMPLETED')) DEFAULT 'PENDING',
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (notification_id, start_user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_broadcast_chunks_notification_id ON broadcast_chunks(notification_id);
@@ -0,0 +1,22 @@
+-- Migration to create the notification deliveries table
+-- One row per recipient and channel of a notification, so a broadcast tracks the status of every user it was sent to
+
+CREATE TABLE IF NOT EXISTS notification_deliveries (
+    id SERIAL PRIMARY KEY,
+    notification_id INTEGER NOT NULL REFERENCES notifications(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    channel VARCHAR(50) NOT NULL,
+
+    status VARCHAR(30) NOT NULL DEFAULT 'PENDING',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP,
+
+    UNIQUE (notification_id, user_id, channel)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_user_id ON notification_deliveries(user_id);
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_status ON notification_deliveries(notification_id, status);
@@ -0,0 +1,26 @@
+-- Migration to create the notification outbox table
+-- The gateway writes a notification and the task that delivers it in one transaction,
+-- the processor relays pending rows into asynq, so the DB and the queue never disagree
+
+CREATE TABLE IF NOT EXISTS notification_outbox (
+    id SERIAL PRIMARY KEY,
+    notification_id INTEGER NOT NULL REFERENCES notifications(id),
+
+    -- asynq task, task_id is also stored as notifications.transaction_id
+    task_id VARCHAR(255) UNIQUE NOT NULL,
+    task_type VARCHAR(100) NOT NULL,
+    payload BYTEA NOT NULL,
+    queue VARCHAR(50) NOT NULL DEFAULT 'default',
+    max_retry INTEGER NOT NULL DEFAULT 10,
+    timeout_seconds INTEGER NOT NULL DEFAULT 180,
+
+    -- relay state
+    status VARCHAR(20) CHECK (status IN ('PENDING', 'SENT')) DEFAULT 'PENDING',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_outbox_pending ON notification_outbox(id) WHERE status = 'PENDING';
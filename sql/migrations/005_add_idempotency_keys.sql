@@ -0,0 +1,12 @@
+-- Migration to support Idempotency-Key on POST /notification
+-- A repeated request with the same key returns the notification saved by the first one
+
+ALTER TABLE notifications
+    ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_idempotency_key
+    ON notifications(idempotency_key) WHERE idempotency_key IS NOT NULL;
+
+-- asynq.Unique TTL of the relayed task, 0 means no uniqueness lock
+ALTER TABLE notification_outbox
+    ADD COLUMN IF NOT EXISTS unique_ttl_seconds INTEGER NOT NULL DEFAULT 0;
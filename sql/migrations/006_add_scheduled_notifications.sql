@@ -0,0 +1,18 @@
+-- Migration for scheduled notifications (send_at / expires_at) and their cancellation
+
+-- asynq.ProcessAt and asynq.Deadline of the relayed task. TIMESTAMPTZ, pgx writes a time.Time into a TIMESTAMP
+-- as its wall clock and drops the offset, so a send_at of 09:00+05:30 would be relayed at 09:00 server time.
+ALTER TABLE notification_outbox
+    ADD COLUMN IF NOT EXISTS process_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS deadline TIMESTAMPTZ;
+
+-- When a scheduled notification left SCHEDULED, stuck ones are failed a day after it instead of a day after creation.
+-- NULL for notifications that were processing from the start, their created_at is when processing started.
+ALTER TABLE notifications
+    ADD COLUMN IF NOT EXISTS processing_started_at TIMESTAMPTZ;
+
+-- A scheduled notification cancelled before the relay picked it up never reaches asynq
+ALTER TABLE notification_outbox
+    DROP CONSTRAINT IF EXISTS notification_outbox_status_check;
+ALTER TABLE notification_outbox
+    ADD CONSTRAINT notification_outbox_status_check CHECK (status IN ('PENDING', 'SENT', 'CANCELLED'));
@@ -0,0 +1,10 @@
+-- Migration to create the notification templates table
+-- The content of a template is in its variants, one per locale, see 009_create_template_variants_table.sql
+
+CREATE TABLE IF NOT EXISTS templates (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
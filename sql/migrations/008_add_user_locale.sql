@@ -0,0 +1,5 @@
+-- Migration to add the preferred locale of users, used to pick the template variant they receive
+-- Locales are lowercase BCP 47 tags like en, de or pt-br
+
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS locale VARCHAR(20) NOT NULL DEFAULT 'en';
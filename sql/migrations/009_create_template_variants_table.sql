@@ -0,0 +1,16 @@
+-- Migration to create the localized variants of templates, run after 007_create_templates_table.sql
+-- Title, description and link can hold {{variable}} placeholders, rendered per recipient by the processor
+
+CREATE TABLE IF NOT EXISTS template_variants (
+    id SERIAL PRIMARY KEY,
+    template_id INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE,
+    locale VARCHAR(20) NOT NULL,
+    title TEXT NOT NULL,
+    description TEXT NOT NULL,
+    link TEXT NOT NULL DEFAULT '',
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (template_id, locale)
+);
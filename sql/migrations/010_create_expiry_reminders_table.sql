@@ -0,0 +1,15 @@
+-- Migration to create the expiry reminders table
+-- One row per reminder sent, so a user is reminded once per threshold of each subscription period.
+-- A renewed subscription has a new end date and gets reminded again.
+
+CREATE TABLE IF NOT EXISTS expiry_reminders (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    threshold_days INTEGER NOT NULL,
+    subscription_end_date TIMESTAMP NOT NULL,
+    notification_id INTEGER REFERENCES notifications(id),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (user_id, threshold_days, subscription_end_date)
+);
@@ -0,0 +1,36 @@
+-- Migration to create the lifecycle rules evaluated by the processor's scheduler
+-- A rule sends a template to a cohort, at most once per cooldown per user
+
+CREATE TABLE IF NOT EXISTS lifecycle_rules (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    cohort_type VARCHAR(30) NOT NULL DEFAULT '',
+    filters JSONB,
+    template_id INTEGER NOT NULL REFERENCES templates(id),
+    channel_id INTEGER,
+    cooldown_hours INTEGER NOT NULL CHECK (cooldown_hours > 0),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+
+    -- stats, updated after every run
+    total_runs INTEGER NOT NULL DEFAULT 0,
+    total_sent INTEGER NOT NULL DEFAULT 0,
+    last_run_at TIMESTAMP,
+    last_run_matched INTEGER NOT NULL DEFAULT 0,
+    last_run_sent INTEGER NOT NULL DEFAULT 0,
+    last_run_notification_id INTEGER REFERENCES notifications(id),
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- One row per user a rule sent to, the cooldown is checked against the latest one
+CREATE TABLE IF NOT EXISTS lifecycle_sends (
+    id SERIAL PRIMARY KEY,
+    rule_id INTEGER NOT NULL REFERENCES lifecycle_rules(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    notification_id INTEGER REFERENCES notifications(id),
+
+    sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_lifecycle_sends_rule_user ON lifecycle_sends(rule_id, user_id, sent_at);
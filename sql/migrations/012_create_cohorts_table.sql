@@ -0,0 +1,14 @@
+-- Migration to create the saved cohorts, named audiences defined with the cohort filters
+-- Broadcasts and lifecycle rules refer to them with filters.saved_cohort_id
+
+CREATE TABLE IF NOT EXISTS cohorts (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    -- one of the built-in cohort types, empty for all active users
+    cohort_type VARCHAR(30) NOT NULL DEFAULT '',
+    filters JSONB,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,8 @@
+-- Migration for streaming cohorts, which page through users by (created_at, id)
+-- A NULL created_at would drop out of the keyset comparison, so backfill it and make the column required
+
+UPDATE users SET created_at = COALESCE(updated_at, CURRENT_TIMESTAMP) WHERE created_at IS NULL;
+
+ALTER TABLE users ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_created_at_id ON users(created_at, id);
@@ -0,0 +1,25 @@
+-- Migration to create the audience snapshots of bulk broadcasts
+-- The cohort of a broadcast is frozen when its fan-out starts, the chunks send to the snapshot instead of re-running
+-- the cohort query, which moves users between cohorts as CURRENT_DATE changes. Snapshots are kept to audit who was targeted.
+
+CREATE TABLE IF NOT EXISTS broadcast_snapshots (
+    notification_id INTEGER PRIMARY KEY REFERENCES notifications(id),
+
+    -- the audience definition the snapshot was taken with
+    cohort_type VARCHAR(30) NOT NULL DEFAULT '',
+    filters JSONB,
+
+    user_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- set once every user is copied in, the users are committed batch by batch and a retry
+    -- takes a snapshot without it again
+    completed_at TIMESTAMPTZ
+);
+
+-- Filled with COPY, one row per targeted user
+CREATE TABLE IF NOT EXISTS broadcast_snapshot_users (
+    notification_id INTEGER NOT NULL REFERENCES broadcast_snapshots(notification_id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+
+    PRIMARY KEY (notification_id, user_id)
+);
@@ -0,0 +1,6 @@
+-- Migration for the cohort classification, which reads is_premium_user as a plain boolean
+-- A NULL premium flag has always meant a user who never subscribed
+
+UPDATE users SET is_premium_user = FALSE WHERE is_premium_user IS NULL;
+
+ALTER TABLE users ALTER COLUMN is_premium_user SET NOT NULL;
@@ -0,0 +1,9 @@
+-- Migration to create the global suppression list
+-- Users on it are left out of every bulk broadcast, e.g. after they complained or asked not to be messaged
+
+CREATE TABLE IF NOT EXISTS suppressions (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id),
+    reason TEXT NOT NULL DEFAULT '',
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,8 @@
+-- Migration to record the exclusions of a broadcast on its audience snapshot
+-- The snapshot only has the users left after the exclusions, excluded has how many users each of them removed
+
+ALTER TABLE broadcast_snapshots ADD COLUMN IF NOT EXISTS exclusions JSONB;
+ALTER TABLE broadcast_snapshots ADD COLUMN IF NOT EXISTS excluded JSONB NOT NULL DEFAULT '{}';
+
+-- Finds the users messaged recently among a batch of broadcast recipients
+CREATE INDEX IF NOT EXISTS idx_notification_deliveries_user_delivered_at ON notification_deliveries(user_id, delivered_at);
@@ -33,6 +33,7 @@ type UserSeed struct {
 	SubscriptionEndDate     *time.Time `json:"subscription_end_date"`
 	NotificationPreferences string     `json:"notification_preferences"`
 	Timezone                string     `json:"timezone"`
+	Locale                  string     `json:"locale"`
 	CreatedAt               time.Time  `json:"created_at"`
 	LastLoginAt             *time.Time `json:"last_login_at"`
 	IsActive                bool       `json:"is_active"`
@@ -139,6 +140,7 @@ func generateUser(index int) UserSeed {
 		SubscriptionTier:        tier,
 		NotificationPreferences: generateNotificationPrefs(),
 		Timezone:                getRandomTimezone(),
+		Locale:                  getRandomLocale(),
 		CreatedAt: gofakeit.DateRange(
 			time.Now().AddDate(-2, 0, 0), // 2 years ago
 			time.Now(),
@@ -205,6 +207,11 @@ func getRandomTimezone() string {
 	return timezones[rand.Intn(len(timezones))]
 }
 
+func getRandomLocale() string {
+	locales := []string{"en", "en", "en", "en-us", "en-gb", "de", "de-at", "hi", "fr", "ja", "pt-br"}
+	return locales[rand.Intn(len(locales))]
+}
+
 func insertUserBatch(dbPool *pgxpool.Pool, users []UserSeed) error {
 	ctx := context.Background()
 
@@ -212,8 +219,8 @@ func insertUserBatch(dbPool *pgxpool.Pool, users []UserSeed) error {
 		INSERT INTO users (
 			email, username, first_name, last_name, is_premium_user,
 			subscription_tier, subscription_start_date, subscription_end_date,
-			notification_preferences, timezone, created_at, last_login_at, is_active
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+			notification_preferences, timezone, locale, created_at, last_login_at, is_active
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	`
 
 	batch := &pgx.Batch{}
@@ -229,6 +236,7 @@ func insertUserBatch(dbPool *pgxpool.Pool, users []UserSeed) error {
 			user.SubscriptionEndDate,
 			user.NotificationPreferences,
 			user.Timezone,
+			user.Locale,
 			user.CreatedAt,
 			user.LastLoginAt,
 			user.IsActive,